@@ -0,0 +1,80 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jrpc2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/creachadair/mds/mctx"
+)
+
+// authKey is the name of the non-standard message field that carries the
+// credentials of a request, when authorization is enabled.
+const authKey = "auth"
+
+// WithCredentials returns a context derived from ctx that carries creds.  A
+// [Client] attaches the credentials from the context of each call or
+// notification to the request, as a non-standard "auth" field:
+//
+//	{"jsonrpc":"2.0", "id":1, "method":"M", "auth":"secret"}
+//
+// The creds value must be JSON-marshalable.  Only a [Server] with an Authorize
+// function accepts requests with credentials; other servers reject them as
+// invalid.
+func WithCredentials(ctx context.Context, creds any) context.Context {
+	return credsKey.Attach(ctx, creds)
+}
+
+var credsKey = mctx.New[any]("credentials")
+
+// injectCreds attaches the credentials from ctx (if any) to msg.
+func injectCreds(ctx context.Context, msg *jmessage) error {
+	creds := credsKey.Lookup(ctx).Get()
+	if creds == nil {
+		return nil
+	}
+	bits, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	if msg.X == nil {
+		msg.X = make(map[string]json.RawMessage)
+	}
+	msg.X[authKey] = bits
+	return nil
+}
+
+// Credentials returns the credentials sent with r, as JSON, or nil if r does
+// not have credentials (see [WithCredentials]).
+func (r *Request) Credentials() json.RawMessage { return r.ext[authKey] }
+
+// authorize checks whether req is authorized to run, and if not returns an
+// Unauthorized error.  The built-in rpc.cancel method is always authorized,
+// since it only affects requests on the same connection, which were
+// authorized when they arrived.
+func (s *Server) authorize(ctx context.Context, req *Request) error {
+	if s.authz == nil || (req.method == rpcCancel && s.isBuiltin(req.method)) {
+		return nil
+	}
+	err := s.authz(ctx, req)
+	if err == nil {
+		return nil
+	}
+	s.log("Request for %q not authorized: %v", req.Method(), err)
+	s.metrics.add(metricUnauthorized, 1)
+	var jerr *Error
+	if errors.As(err, &jerr) {
+		return &Error{Code: Unauthorized, Message: jerr.Message, Data: jerr.Data}
+	}
+	return &Error{Code: Unauthorized, Message: err.Error()}
+}
+
+// authKeys returns the extension keys to accept from the wire for authz.
+func authKeys(authz func(context.Context, *Request) error) []string {
+	if authz == nil {
+		return nil
+	}
+	return []string{authKey}
+}
@@ -50,11 +50,32 @@ type Namer interface {
 // do not receive the request directly.
 type Handler = func(context.Context, *Request) (any, error)
 
+// An Interceptor wraps the invocation of a [Handler] by a [Server]. It
+// receives the handler context, the inbound request, and the next handler in
+// the chain. An interceptor may inspect or modify the context and request
+// before calling next, inspect or replace the result and error that next
+// reports, or decline to call next at all.
+type Interceptor func(ctx context.Context, req *Request, next Handler) (any, error)
+
+// intercept returns a Handler that invokes h wrapped by the specified
+// interceptors, with ics[0] outermost. If len(ics) == 0, h is returned as-is.
+func intercept(h Handler, ics []Interceptor) Handler {
+	for i := len(ics) - 1; i >= 0; i-- {
+		ic, next := ics[i], h
+		h = func(ctx context.Context, req *Request) (any, error) {
+			return ic(ctx, req, next)
+		}
+	}
+	return h
+}
+
 // A Request is a request message from a client to a server.
 type Request struct {
 	id     json.RawMessage // the request ID, nil for notifications
 	method string          // the name of the method being requested
 	params json.RawMessage // method parameters
+
+	ext map[string]json.RawMessage // extension fields, or nil
 }
 
 // IsNotification reports whether the request is a notification, and thus does
@@ -112,13 +133,15 @@ type Response struct {
 	id     string
 	err    *Error
 	result json.RawMessage
+	ext    map[string]json.RawMessage // extension fields, or nil
 
 	// Waiters synchronize on reading from ch. The first successful reader from
 	// ch completes the request and is responsible for updating rsp and then
 	// closing ch. The client owns writing to ch, and is responsible to ensure
 	// that at most one write is ever performed.
-	ch     chan *jmessage
-	cancel func()
+	ch       chan *jmessage
+	cancel   func()
+	progress func(json.RawMessage) // if set, receives progress reports
 }
 
 // ID returns the request identifier for r.
@@ -167,6 +190,7 @@ func (r *Response) MarshalJSON() ([]byte, error) {
 		ID: json.RawMessage(r.id),
 		R:  r.result,
 		E:  r.err,
+		X:  r.ext,
 	}).toJSON()
 }
 
@@ -184,6 +208,7 @@ func (r *Response) wait() {
 		// waiters all get the same response, and do not race on accessing it.
 		r.err = raw.E
 		r.result = raw.R
+		r.ext = raw.X
 		close(r.ch)
 		r.cancel() // release the context observer
 
@@ -0,0 +1,299 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jrpc2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/creachadair/mds/cache"
+)
+
+// CacheOptions are settings for a [Cache].  A nil *CacheOptions is valid and
+// provides default values, but does not enable caching for any methods.
+type CacheOptions struct {
+	// The methods whose results may be cached, by name, and for each the
+	// duration for which a cached result remains valid.  A zero duration
+	// uses the TTL setting.  Results are not cached for other methods.
+	Methods map[string]time.Duration
+
+	// The default duration for which a cached result remains valid.  If zero
+	// or negative, results do not expire, but may still be evicted to make
+	// room for others or invalidated explicitly.
+	TTL time.Duration
+
+	// The maximum number of results to cache.  If zero or negative, a default
+	// limit of 1000 results is used.
+	MaxEntries int
+}
+
+func (o *CacheOptions) methods() map[string]time.Duration {
+	if o == nil {
+		return nil
+	}
+	return o.Methods
+}
+
+func (o *CacheOptions) ttl() time.Duration {
+	if o == nil || o.TTL < 0 {
+		return 0
+	}
+	return o.TTL
+}
+
+func (o *CacheOptions) maxEntries() int64 {
+	if o == nil || o.MaxEntries <= 0 {
+		return 1000
+	}
+	return int64(o.MaxEntries)
+}
+
+// A Cache caches the results of calls to idempotent methods, keyed by the
+// method name, the parameters, and the credentials of the request (see
+// [WithCredentials]), so that a result computed for one caller is not served
+// to another.  To use a cache, set the Cache field of the [ServerOptions].  Servers that share a Cache share its
+// results.  A Cache is safe for concurrent use by multiple goroutines.
+//
+// Only successful results are cached.  If a request arrives for a method and
+// parameters whose result is not cached, and another such request is already
+// in progress, the new request waits for and shares the result of the request
+// in progress rather than invoking the handler again.  If the request in
+// progress is cancelled or times out, waiting requests retry the call rather
+// than sharing its error.
+//
+// Two requests have the same parameters if their parameters are equal as
+// JSON values; differences in spacing and the order of object fields do not
+// matter, and null parameters are the same as none.  Cache hits (including
+// shared results) and misses are counted in the server metrics.
+//
+// A handler that modifies state on which cached results depend should call
+// Invalidate or InvalidateMethod to remove results that are no longer valid.
+type Cache struct {
+	methods map[string]time.Duration
+	ttl     time.Duration
+	results *cache.Cache[string, cacheEntry]
+
+	mu      sync.Mutex
+	epoch   int64                          // incremented by each invalidation
+	gen     map[string]int64               // per-method generation (see InvalidateMethod)
+	flight  map[string]*cacheFlight        // calls in progress, by cache key
+	callers map[string]map[string]struct{} // cache keys for each call key (see Invalidate)
+}
+
+// A cacheEntry is a cached result, which is valid until the expiration time
+// (if set).
+type cacheEntry struct {
+	call    string // the call key, without credentials
+	result  json.RawMessage
+	expires time.Time
+}
+
+// A cacheFlight is a call in progress for a cached method, whose result is
+// shared with other requests that arrive while it is running.
+type cacheFlight struct {
+	done   chan struct{} // closed when the call is complete
+	result json.RawMessage
+	err    error
+}
+
+// NewCache constructs a new empty Cache with the given options.
+func NewCache(opts *CacheOptions) *Cache {
+	c := &Cache{
+		methods: opts.methods(),
+		ttl:     opts.ttl(),
+		gen:     make(map[string]int64),
+		flight:  make(map[string]*cacheFlight),
+		callers: make(map[string]map[string]struct{}),
+	}
+	c.results = cache.New(cache.LRU[string, cacheEntry]().
+		WithLimit(opts.maxEntries()).
+		OnEvict(c.forgetLocked))
+	return c
+}
+
+// Invalidate removes the cached results for a call to method with the given
+// parameters, for all callers.  The params value must be JSON-marshalable.
+// A call to Invalidate also prevents caching the results of any calls that
+// were already in progress when Invalidate was called.
+func (c *Cache) Invalidate(method string, params any) error {
+	bits, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	cp, err := canonicalParams(bits)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	for key := range c.callers[c.keyLocked(method, cp)] {
+		c.results.Remove(key) // N.B. this updates c.callers
+	}
+	return nil
+}
+
+// InvalidateMethod removes all the cached results for calls to method.  It
+// also prevents caching the results of any calls that were already in
+// progress when InvalidateMethod was called.
+func (c *Cache) InvalidateMethod(method string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.gen[method]++ // N.B. old entries are no longer reachable, and will age out
+}
+
+// Clear removes all cached results from c.
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.results.Clear()
+}
+
+// Len reports the number of results currently cached in c, including any
+// that have expired but have not yet been removed.
+func (c *Cache) Len() int { return c.results.Len() }
+
+// keyLocked returns the call key for a call to method with canonical
+// parameters cp.  The caller must hold c.mu.
+func (c *Cache) keyLocked(method, cp string) string {
+	return method + "\x00" + strconv.FormatInt(c.gen[method], 10) + "\x00" + cp
+}
+
+// forgetLocked removes key from the cache keys for the call key of e.  It is
+// called by c.results for each entry it removes, which it only does while the
+// caller holds c.mu.
+func (c *Cache) forgetLocked(key string, e cacheEntry) {
+	keys := c.callers[e.call]
+	delete(keys, key)
+	if len(keys) == 0 {
+		delete(c.callers, e.call)
+	}
+}
+
+// intercept is an Interceptor that serves the results of calls to cached
+// methods from c, and records the results of calls that miss.
+func (c *Cache) intercept(ctx context.Context, req *Request, next Handler) (any, error) {
+	ttl, ok := c.methods[req.method]
+	if !ok || req.IsNotification() {
+		return next(ctx, req)
+	}
+	cp, err := canonicalParams(req.params)
+	if err != nil {
+		return next(ctx, req) // let the handler report the problem
+	}
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+
+	c.mu.Lock()
+	call, epoch := c.keyLocked(req.method, cp), c.epoch
+	key := call + "\x00" + string(req.Credentials())
+	if e, ok := c.results.Get(key); ok && (e.expires.IsZero() || time.Now().Before(e.expires)) {
+		c.mu.Unlock()
+		c.count(ctx, metricCacheHits)
+		return e.result, nil
+	}
+	if f, ok := c.flight[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-f.done:
+			if isContextError(f.err) && ctx.Err() == nil {
+				// The call we were waiting for was cancelled or timed out,
+				// but this request was not: Try again on our own behalf.
+				return c.intercept(ctx, req, next)
+			} else if f.err == nil {
+				c.count(ctx, metricCacheHits)
+			}
+			return f.result, f.err
+		}
+	}
+	f := &cacheFlight{done: make(chan struct{}), err: errCallAborted}
+	c.flight[key] = f
+	c.mu.Unlock()
+	defer c.finish(call, key, epoch, ttl, f)
+
+	c.count(ctx, metricCacheMisses)
+	f.result, f.err = c.call(ctx, req, next)
+	return f.result, f.err
+}
+
+// errCallAborted is the error shared with waiting requests if the call they
+// are waiting for panics.
+var errCallAborted = &Error{Code: InternalError, Message: "shared call did not complete"}
+
+// finish completes the call in progress f, and caches its result under key
+// for the given call key if it succeeded and no invalidation has occurred
+// since epoch.
+func (c *Cache) finish(call, key string, epoch int64, ttl time.Duration, f *cacheFlight) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.flight, key)
+	close(f.done)
+	if f.err == nil && c.epoch == epoch {
+		e := cacheEntry{call: call, result: f.result}
+		if ttl > 0 {
+			e.expires = time.Now().Add(ttl)
+		}
+		if c.results.Put(key, e) {
+			if c.callers[call] == nil {
+				c.callers[call] = make(map[string]struct{})
+			}
+			c.callers[call][key] = struct{}{}
+		}
+	}
+}
+
+// call invokes next and returns its result encoded as JSON.
+func (c *Cache) call(ctx context.Context, req *Request, next Handler) (json.RawMessage, error) {
+	v, err := next(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// isContextError reports whether err reports the cancellation or expiry of a
+// context.
+func isContextError(err error) bool {
+	code := ErrorCode(err)
+	return code == Cancelled || code == DeadlineExceeded
+}
+
+// count adds one to the named metric of the server running the handler with
+// context ctx, or to the shared metrics if there is no such server.
+func (c *Cache) count(ctx context.Context, name string) {
+	if srv := serverKey.Lookup(ctx).Get(); srv != nil {
+		srv.metrics.add(name, 1)
+	} else {
+		serverMetrics.Add(name, 1)
+	}
+}
+
+// canonicalParams returns a canonical encoding of the JSON parameters, so
+// that equivalent parameters share an encoding.
+func canonicalParams(params json.RawMessage) (string, error) {
+	if len(bytes.TrimSpace(params)) == 0 {
+		return "", nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	} else if v == nil {
+		return "", nil // N.B. null is equivalent to no parameters
+	}
+	bits, err := json.Marshal(v) // N.B. object keys are sorted
+	if err != nil {
+		return "", err
+	}
+	return string(bits), nil
+}
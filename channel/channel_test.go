@@ -3,6 +3,7 @@
 package channel_test
 
 import (
+	"errors"
 	"io"
 	"strconv"
 	"strings"
@@ -153,3 +154,74 @@ func TestEmptyMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestMaxSize(t *testing.T) {
+	const limit = 100
+	small := `{"ok":true}`
+	large := `["` + strings.Repeat("x", 3*limit) + `"]`
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				lhs, rhs := newPipe(channel.MaxSize(test.framing, limit))
+				defer lhs.Close()
+				defer rhs.Close()
+
+				go func() {
+					for _, msg := range []string{small, large, small} {
+						if err := lhs.Send([]byte(msg)); err != nil {
+							t.Errorf("Send failed: %v", err)
+						}
+					}
+				}()
+
+				if got, err := rhs.Recv(); err != nil || string(got) != small {
+					t.Errorf("Recv 1: got %#q, %v; want %#q, nil", got, err, small)
+				}
+				_, err := rhs.Recv()
+				var tooLarge *channel.RecordTooLargeError
+				if !errors.As(err, &tooLarge) {
+					t.Errorf("Recv 2: got error %v, want %T", err, tooLarge)
+				} else if tooLarge.Limit != limit {
+					t.Errorf("Recv 2: got limit %d, want %d", tooLarge.Limit, limit)
+				}
+
+				// The channel should recover after an oversized record.
+				if got, err := rhs.Recv(); err != nil || string(got) != small {
+					t.Errorf("Recv 3: got %#q, %v; want %#q, nil", got, err, small)
+				}
+			})
+		})
+	}
+
+	// A RawJSON channel cannot recover once it stops reading a large record.
+	t.Run("RawJSON-Huge", func(t *testing.T) {
+		huge := `["` + strings.Repeat("x", 10000) + `"]`
+		ch := channel.MaxSize(channel.RawJSON, limit)(strings.NewReader(huge+small), nopCloser{})
+		if got, err := ch.Recv(); !errors.As(err, new(*channel.RecordTooLargeError)) {
+			t.Errorf("Recv 1: got %d bytes, %v; want %T", len(got), err, (*channel.RecordTooLargeError)(nil))
+		}
+		if got, err := ch.Recv(); err == nil {
+			t.Errorf("Recv 2: got %#q, want error", got)
+		}
+	})
+
+	// A Line channel discards a record larger than its read buffer.
+	t.Run("Line-Huge", func(t *testing.T) {
+		huge := strings.Repeat("x", 10000)
+		ch := channel.MaxSize(channel.Line, limit)(strings.NewReader(huge+"\n"+small+"\n"), nopCloser{})
+		var tooLarge *channel.RecordTooLargeError
+		if _, err := ch.Recv(); !errors.As(err, &tooLarge) {
+			t.Errorf("Recv 1: got %v, want %T", err, tooLarge)
+		} else if tooLarge.Size != int64(len(huge)) {
+			t.Errorf("Recv 1: got size %d, want %d", tooLarge.Size, len(huge))
+		}
+		if got, err := ch.Recv(); err != nil || string(got) != small {
+			t.Errorf("Recv 2: got %#q, %v; want %#q, nil", got, err, small)
+		}
+	})
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
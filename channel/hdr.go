@@ -74,8 +74,11 @@ type hdr struct {
 	rd    *bufio.Reader
 	buf   *bytes.Buffer
 	rbuf  []byte
+	limit int // maximum record size, or 0 for no limit
 }
 
+func (h *hdr) setMaxSize(n int) { h.limit = n }
+
 // Send implements part of the Channel interface.
 func (h *hdr) Send(msg []byte) error {
 	h.buf.Reset()
@@ -135,6 +138,13 @@ func (h *hdr) Recv() ([]byte, error) {
 	if err != nil || size < 0 {
 		return nil, errors.New("invalid content-length")
 	}
+	if h.limit > 0 && size > h.limit {
+		// Discard the body so that the next record can be read.
+		if _, err := io.CopyN(io.Discard, h.rd, int64(size)); err != nil {
+			return nil, err
+		}
+		return nil, &RecordTooLargeError{Size: int64(size), Limit: h.limit}
+	}
 
 	// We need to use ReadFull here because the buffered reader may not have a
 	// big enough buffer to deliver the whole message, and will only issue a
@@ -4,6 +4,7 @@ package channel
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 )
 
@@ -17,19 +18,24 @@ const bufSize = 4096
 // recover after a message that is not syntactically valid JSON.  Applications
 // that need a channel to survive invalid JSON should avoid this framing.
 func RawJSON(r io.Reader, wc io.WriteCloser) Channel {
-	return jsonc{wc: wc, dec: json.NewDecoder(r), buf: make([]byte, bufSize)}
+	cr := &countReader{r: r}
+	return &jsonc{wc: wc, cr: cr, dec: json.NewDecoder(cr), buf: make([]byte, bufSize)}
 }
 
 // A jsonc implements channel.Channel. Messages sent on a raw channel are not
 // explicitly framed, and messages received are framed by JSON syntax.
 type jsonc struct {
 	wc  io.WriteCloser
+	cr  *countReader
 	dec *json.Decoder
 	buf json.RawMessage
+	err error // if set, the channel cannot recover from a previous error
 }
 
+func (c *jsonc) setMaxSize(n int) { c.cr.limit = n }
+
 // Send implements part of the [Channel] interface.
-func (c jsonc) Send(msg []byte) error {
+func (c *jsonc) Send(msg []byte) error {
 	if len(msg) == 0 || isNull(msg) {
 		_, err := io.WriteString(c.wc, "null\n")
 		return err
@@ -41,18 +47,52 @@ func (c jsonc) Send(msg []byte) error {
 // Recv implements part of the [Channel] interface. It reports an error if the
 // message is not a structurally valid JSON value. It is safe for the caller to
 // treat any record returned as a [json.RawMessage].
-func (c jsonc) Recv() ([]byte, error) {
+func (c *jsonc) Recv() ([]byte, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.cr.start = c.dec.InputOffset()
 	c.buf = c.buf[:0] // reset
 	if err := c.dec.Decode(&c.buf); err != nil {
+		if _, ok := err.(*RecordTooLargeError); ok {
+			c.err = fmt.Errorf("channel is unusable after an oversized record: %v", err)
+		}
 		return nil, err
 	} else if isNull(c.buf) {
 		return nil, nil
+	} else if c.cr.limit > 0 && len(c.buf) > c.cr.limit {
+		return nil, &RecordTooLargeError{Size: int64(len(c.buf)), Limit: c.cr.limit}
 	}
 	return c.buf, nil
 }
 
+// A countReader wraps an io.Reader to limit how much data the decoder may
+// buffer for a single record.
+type countReader struct {
+	r     io.Reader
+	nr    int64 // total bytes read from r
+	start int64 // offset of the start of the current record
+	limit int   // maximum record size, or 0 for no limit
+}
+
+func (c *countReader) Read(data []byte) (int, error) {
+	if c.limit > 0 {
+		// Allow the decoder to read ahead by up to one buffer beyond the limit,
+		// since it cannot know where the record ends until it sees the end.
+		room := int64(c.limit+bufSize) - (c.nr - c.start)
+		if room <= 0 {
+			return 0, &RecordTooLargeError{Size: -1, Limit: c.limit}
+		} else if int64(len(data)) > room {
+			data = data[:room]
+		}
+	}
+	nr, err := c.r.Read(data)
+	c.nr += int64(nr)
+	return nr, err
+}
+
 // Close implements part of the [Channel] interface.
-func (c jsonc) Close() error { return c.wc.Close() }
+func (c *jsonc) Close() error { return c.wc.Close() }
 
 func isNull(msg json.RawMessage) bool {
 	return len(msg) == 4 && msg[0] == 'n' && msg[1] == 'u' && msg[2] == 'l' && msg[3] == 'l'
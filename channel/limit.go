@@ -0,0 +1,68 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package channel
+
+import (
+	"fmt"
+	"io"
+)
+
+// A RecordTooLargeError is reported by the Recv method of a channel with a
+// maximum record size (see [MaxSize]) when a received record exceeds that
+// size.
+type RecordTooLargeError struct {
+	Size  int64 // the size of the record in bytes, or -1 if unknown
+	Limit int   // the maximum record size
+}
+
+func (e *RecordTooLargeError) Error() string {
+	if e.Size < 0 {
+		return fmt.Sprintf("record exceeds maximum size %d", e.Limit)
+	}
+	return fmt.Sprintf("record size %d exceeds maximum size %d", e.Size, e.Limit)
+}
+
+// sizeLimiter is implemented by channels in this package that can enforce a
+// maximum record size before buffering the record.
+type sizeLimiter interface {
+	setMaxSize(n int)
+}
+
+// MaxSize returns a framing that behaves as f, except that the Recv method of
+// its channels reports an error of concrete type [*RecordTooLargeError] for a
+// record longer than n bytes.  If n ≤ 0, MaxSize returns f unmodified.
+//
+// The framings defined by this package check the limit before allocating
+// space for the record. The [Header] and [Split] framings discard the excess
+// record, so that subsequent records can be received. The [RawJSON] framing
+// cannot recover after an oversized record, and reports an error for all
+// subsequent calls to Recv.
+//
+// For other framings, the limit is checked after the record is received.
+func MaxSize(f Framing, n int) Framing {
+	if n <= 0 {
+		return f
+	}
+	return func(r io.Reader, wc io.WriteCloser) Channel {
+		ch := f(r, wc)
+		if s, ok := ch.(sizeLimiter); ok {
+			s.setMaxSize(n)
+			return ch
+		}
+		return maxSize{Channel: ch, limit: n}
+	}
+}
+
+// maxSize is a wrapper for a channel that does not implement sizeLimiter.
+type maxSize struct {
+	Channel
+	limit int
+}
+
+func (m maxSize) Recv() ([]byte, error) {
+	msg, err := m.Channel.Recv()
+	if err == nil && len(msg) > m.limit {
+		return nil, &RecordTooLargeError{Size: int64(len(msg)), Limit: m.limit}
+	}
+	return msg, err
+}
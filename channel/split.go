@@ -18,7 +18,7 @@ var Line = Split('\n')
 // contain the split byte internally.
 func Split(b byte) Framing {
 	return func(r io.Reader, wc io.WriteCloser) Channel {
-		return split{split: b, wc: wc, buf: bufio.NewReader(r)}
+		return &split{split: b, wc: wc, buf: bufio.NewReader(r)}
 	}
 }
 
@@ -28,8 +28,11 @@ type split struct {
 	split byte
 	wc    io.WriteCloser
 	buf   *bufio.Reader
+	limit int // maximum record size, or 0 for no limit
 }
 
+func (c *split) setMaxSize(n int) { c.limit = n }
+
 // Send implements part of the [Channel] interface.  It reports an error if msg
 // contains a split byte.
 func (c split) Send(msg []byte) error {
@@ -47,6 +50,9 @@ func (c split) Recv() ([]byte, error) {
 	for {
 		chunk, err := c.buf.ReadSlice(c.split)
 		buf.Write(chunk)
+		if c.limit > 0 && buf.Len() > c.limit+1 { // +1 for the split byte
+			return nil, c.discard(int64(buf.Len()), err)
+		}
 		if err == bufio.ErrBufferFull {
 			continue // incomplete line
 		}
@@ -58,5 +64,22 @@ func (c split) Recv() ([]byte, error) {
 	}
 }
 
+// discard skips the remainder of an oversized record of which n bytes have
+// already been read, and err is the error from the last read.
+func (c split) discard(n int64, err error) error {
+	for err == bufio.ErrBufferFull {
+		var chunk []byte
+		chunk, err = c.buf.ReadSlice(c.split)
+		n += int64(len(chunk))
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if err == nil {
+		n-- // the split byte
+	}
+	return &RecordTooLargeError{Size: n, Limit: c.limit}
+}
+
 // Close implements part of the [Channel] interface.
 func (c split) Close() error { return c.wc.Close() }
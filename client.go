@@ -17,11 +17,17 @@ import (
 // responses on a [channel.Channel] provided by the constructor.
 type Client struct {
 	done *sync.WaitGroup // done when the reader is finished at shutdown time
+	recv sync.WaitGroup  // done when received messages have been delivered
+	last chan struct{}   // closed when the latest batch is delivered (reader only)
 
 	log   func(string, ...any) // write debug logs here
 	snote func(*jmessage)
 	scall func(context.Context, *jmessage) []byte
 	chook func(*Client, *Response)
+	trace Tracer   // propagate trace context, or nil
+	xkeys []string // extension fields accepted from the server
+	pcan  bool     // send rpc.cancel when a request context ends
+	ptime bool     // send time budgets with calls
 	shook func(*Client, error)
 
 	cbctx    context.Context    // terminates when the client is closed
@@ -35,7 +41,10 @@ type Client struct {
 }
 
 // NewClient returns a new client that communicates with the server via ch.
+// It will panic if opts list an invalid extension key (see
+// ClientOptions.Extensions).
 func NewClient(ch channel.Channel, opts *ClientOptions) *Client {
+	mustCheckExtensionKeys(opts.extensions())
 	cbctx, cbcancel := context.WithCancel(context.Background())
 	c := &Client{
 		done:  new(sync.WaitGroup),
@@ -43,6 +52,10 @@ func NewClient(ch channel.Channel, opts *ClientOptions) *Client {
 		snote: opts.handleNotification(),
 		scall: opts.handleCallback(),
 		chook: opts.handleCancel(),
+		trace: opts.tracer(),
+		xkeys: opts.extensionKeys(),
+		ptime: opts.propagateTimeouts(),
+		pcan:  opts.propagateCancel(),
 		shook: opts.handleStop(),
 
 		cbctx:    cbctx,
@@ -75,12 +88,16 @@ func (c *Client) accept(ch receiver) error {
 	var in jmessages
 	bits, err := ch.Recv()
 	if err == nil {
-		err = in.parseJSON(bits)
+		err = in.parseJSON(bits, c.xkeys...)
 	}
 	if err != nil {
 		if !isUninteresting(err) {
 			c.log("Decoding error: %v", err)
 		}
+
+		// Ensure responses received before the failure are delivered before the
+		// pending requests are terminated.
+		c.recv.Wait()
 		c.mu.Lock()
 		defer c.stopLocked(err)()
 		c.mu.Unlock()
@@ -88,7 +105,18 @@ func (c *Client) accept(ch receiver) error {
 	}
 
 	c.log("Received %d responses", len(in))
+	c.recv.Add(1)
+
+	// Deliver each batch after its predecessor, so that messages are handled in
+	// order of receipt, for example progress reports before the response.
+	prev, next := c.last, make(chan struct{})
+	c.last = next
 	c.done.Go(func() {
+		defer c.recv.Done()
+		defer close(next)
+		if prev != nil {
+			<-prev
+		}
 		c.mu.Lock()
 		defer c.mu.Unlock()
 		for _, rsp := range in {
@@ -136,7 +164,10 @@ func (c *Client) handleRequestLocked(msg *jmessage) {
 // response; we just drop it in their channel.  The channel is buffered so we
 // don't need to rendezvous.
 func (c *Client) deliverLocked(rsp *jmessage) {
-	if rsp.isRequestOrNotification() {
+	if rsp.M == rpcProgress && rsp.isNotification() {
+		c.handleProgressLocked(rsp)
+		return
+	} else if rsp.isRequestOrNotification() {
 		c.handleRequestLocked(rsp)
 		return
 	}
@@ -161,7 +192,8 @@ func (c *Client) deliverLocked(rsp *jmessage) {
 
 // req constructs a fresh request for the specified method and parameters.
 // This does not transmit the request to the server; use c.send to do so.
-func (c *Client) req(ctx context.Context, method string, params any) (*jmessage, error) {
+// Extension fields are taken from ctx and then from ext, which may be nil.
+func (c *Client) req(ctx context.Context, method string, params any, ext map[string]any) (*jmessage, error) {
 	bits, err := c.marshalParams(ctx, method, params)
 	if err != nil {
 		return nil, err
@@ -171,20 +203,37 @@ func (c *Client) req(ctx context.Context, method string, params any) (*jmessage,
 	defer c.mu.Unlock()
 	id := json.RawMessage(strconv.FormatInt(c.nextID, 10))
 	c.nextID++
-	return &jmessage{
-		ID: id,
-		M:  method,
-		P:  bits,
-	}, nil
+	msg := &jmessage{ID: id, M: method, P: bits}
+	if err := injectExtensions(ctx, ext, msg); err != nil {
+		return nil, err
+	}
+	if c.ptime {
+		injectTimeout(ctx, msg)
+	}
+	injectIdempotencyKey(ctx, msg)
+	injectTrace(c.trace, ctx, msg)
+	if err := injectCreds(ctx, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
 }
 
 // note constructs a notification request for the specified method and parameters.
-func (c *Client) note(ctx context.Context, method string, params any) (*jmessage, error) {
+// Extension fields are taken from ctx and then from ext, which may be nil.
+func (c *Client) note(ctx context.Context, method string, params any, ext map[string]any) (*jmessage, error) {
 	bits, err := c.marshalParams(ctx, method, params)
 	if err != nil {
 		return nil, err
 	}
-	return &jmessage{M: method, P: bits}, nil
+	msg := &jmessage{M: method, P: bits}
+	if err := injectExtensions(ctx, ext, msg); err != nil {
+		return nil, err
+	}
+	injectTrace(c.trace, ctx, msg)
+	if err := injectCreds(ctx, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
 }
 
 // send transmits the specified requests to the server and returns a slice of
@@ -194,8 +243,10 @@ func (c *Client) note(ctx context.Context, method string, params any) (*jmessage
 // expects a response (that is, all those that are not notifications). If all
 // the requests are notifications, the slice will be empty.
 //
+// The settings in opts, which may be nil, apply to each of the requests.
+//
 // This method blocks until the entire batch of requests has been transmitted.
-func (c *Client) send(ctx context.Context, reqs jmessages) ([]*Response, error) {
+func (c *Client) send(ctx context.Context, reqs jmessages, opts *CallOptions) ([]*Response, error) {
 	if len(reqs) == 0 {
 		return nil, errors.New("empty request batch")
 	}
@@ -213,6 +264,7 @@ func (c *Client) send(ctx context.Context, reqs jmessages) ([]*Response, error)
 	for _, req := range reqs {
 		if id := string(req.ID); id != "" {
 			pctx, p := newPending(ctx, id)
+			p.progress = opts.onProgress()
 			pends = append(pends, p)
 			pctxs = append(pctxs, pctx)
 		}
@@ -271,12 +323,24 @@ func (c *Client) waitComplete(pctx context.Context, id string, p *Response) {
 		E:  jerr,
 	}
 
-	// If there is a cancellation hook, give it a chance to run.
-	if c.chook != nil {
+	// If the server should be told about the cancellation, and the client is
+	// still able to tell it, send a notification. If there is a cancellation
+	// hook, give it a chance to run.
+	propagate := c.pcan && c.err == nil
+	if propagate || c.chook != nil {
 		cleanup = func() {
-			p.wait() // ensure the response has settled
-			c.log("Calling OnCancel for id %q", id)
-			c.chook(c, p)
+			if propagate {
+				c.log("Sending %s for id %q", rpcCancel, id)
+				ids := []json.RawMessage{json.RawMessage(id)}
+				if err := c.Notify(context.Background(), rpcCancel, ids); err != nil {
+					c.log("Sending %s failed: %v", rpcCancel, err)
+				}
+			}
+			if c.chook != nil {
+				p.wait() // ensure the response has settled
+				c.log("Calling OnCancel for id %q", id)
+				c.chook(c, p)
+			}
 		}
 	}
 }
@@ -293,11 +357,17 @@ func (c *Client) waitComplete(pctx context.Context, id string, p *Response) {
 //	}
 //	handleValidResponse(rsp)
 func (c *Client) Call(ctx context.Context, method string, params any) (*Response, error) {
-	req, err := c.req(ctx, method, params)
+	return c.CallWith(ctx, method, params, nil)
+}
+
+// CallWith behaves as Call, with the settings in opts applied to the call.  A
+// nil opts is equivalent to Call.
+func (c *Client) CallWith(ctx context.Context, method string, params any, opts *CallOptions) (*Response, error) {
+	req, err := c.req(ctx, method, params, nil)
 	if err != nil {
 		return nil, err
 	}
-	rsp, err := c.send(ctx, jmessages{req})
+	rsp, err := c.send(ctx, jmessages{req}, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -332,16 +402,16 @@ func (c *Client) Batch(ctx context.Context, specs []Spec) ([]*Response, error) {
 		var req *jmessage
 		var err error
 		if spec.Notify {
-			req, err = c.note(ctx, spec.Method, spec.Params)
+			req, err = c.note(ctx, spec.Method, spec.Params, spec.Extensions)
 		} else {
-			req, err = c.req(ctx, spec.Method, spec.Params)
+			req, err = c.req(ctx, spec.Method, spec.Params, spec.Extensions)
 		}
 		if err != nil {
 			return nil, err
 		}
 		reqs[i] = req
 	}
-	rsps, err := c.send(ctx, reqs)
+	rsps, err := c.send(ctx, reqs, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -353,20 +423,25 @@ func (c *Client) Batch(ctx context.Context, specs []Spec) ([]*Response, error) {
 
 // A Spec combines a method name and parameter value as part of a Batch.  If
 // the Notify flag is true, the request is sent as a notification.
+//
+// The Extensions map, if set, gives extension fields for the request.  These
+// are applied after any set in the context passed to Batch (see
+// [WithExtension]).
 type Spec struct {
-	Method string
-	Params any
-	Notify bool
+	Method     string
+	Params     any
+	Notify     bool
+	Extensions map[string]any
 }
 
 // Notify transmits a notification to the specified method and parameters.  It
 // blocks until the notification has been sent or ctx ends.
 func (c *Client) Notify(ctx context.Context, method string, params any) error {
-	req, err := c.note(ctx, method, params)
+	req, err := c.note(ctx, method, params, nil)
 	if err != nil {
 		return err
 	}
-	_, err = c.send(ctx, jmessages{req})
+	_, err = c.send(ctx, jmessages{req}, nil)
 	return err
 }
 
@@ -72,6 +72,9 @@ const (
 	SystemError      Code = -32098 // Errors from the operating environment
 	Cancelled        Code = -32097 // Request cancelled (context.Canceled)
 	DeadlineExceeded Code = -32096 // Request deadline exceeded (context.DeadlineExceeded)
+	Overloaded       Code = -32095 // Server has too many pending requests
+	RateLimited      Code = -32094 // Request exceeds the server rate limit
+	Unauthorized     Code = -32093 // Request is not authorized
 )
 
 var stdError = map[Code]string{
@@ -85,6 +88,9 @@ var stdError = map[Code]string{
 	SystemError:      "system error",
 	Cancelled:        "request cancelled",
 	DeadlineExceeded: "deadline exceeded",
+	Overloaded:       "server overloaded",
+	RateLimited:      "rate limit exceeded",
+	Unauthorized:     "unauthorized",
 }
 
 // ErrorCode returns a Code to categorize the specified error.
@@ -0,0 +1,83 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jrpc2
+
+// OpenRPCVersion is the version of the OpenRPC specification implemented by
+// the discovery document a [Server] reports from its rpc.discover method.
+// See https://spec.open-rpc.org.
+const OpenRPCVersion = "1.2.6"
+
+// Describer is an optional interface that an [Assigner] may implement to
+// describe the parameters and results of its methods to the rpc.discover
+// method.
+type Describer interface {
+	// Describe returns descriptions of all known methods, ordered by name.
+	Describe() []*MethodInfo
+}
+
+// DiscoveryDoc is an OpenRPC service description document, as reported by
+// the rpc.discover method of a [Server].
+type DiscoveryDoc struct {
+	OpenRPC string        `json:"openrpc"`
+	Info    DiscoveryInfo `json:"info"`
+	Methods []*MethodInfo `json:"methods"`
+}
+
+// DiscoveryInfo is the metadata section of an OpenRPC document.
+type DiscoveryInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// MethodInfo describes a single method in an OpenRPC document.
+type MethodInfo struct {
+	Name   string               `json:"name"`
+	Params []*ContentDescriptor `json:"params"`
+	Result *ContentDescriptor   `json:"result,omitempty"`
+
+	// How the method accepts its parameters, one of "by-name",
+	// "by-position", or "either". If empty, the structure is unspecified.
+	ParamStructure string `json:"paramStructure,omitempty"`
+
+	// Whether the method rejects unknown fields in its parameters.
+	// This is an extension of the OpenRPC specification.
+	StrictFields bool `json:"x-strictFields,omitempty"`
+}
+
+// ContentDescriptor describes a parameter or result value in an OpenRPC
+// document. The Schema is a JSON Schema for the value.
+type ContentDescriptor struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required,omitempty"`
+	Schema   any    `json:"schema"`
+}
+
+// Discover returns an OpenRPC document describing the methods of s, whose
+// info section is set by the DiscoveryInfo server option.  If the assigner
+// for s implements [Describer], its descriptions are used. Otherwise, if the
+// assigner implements [Namer], methods are listed by name only.  The
+// parameters of a method whose description has nil Params are reported as
+// unknown.
+func (s *Server) Discover() *DiscoveryDoc {
+	doc := &DiscoveryDoc{
+		OpenRPC: OpenRPCVersion,
+		Info:    s.dinfo,
+		Methods: []*MethodInfo{},
+	}
+	mux := s.assigner()
+	if d, ok := mux.(Describer); ok {
+		doc.Methods = append(doc.Methods, d.Describe()...)
+	} else if n, ok := mux.(Namer); ok {
+		for _, name := range n.Names() {
+			doc.Methods = append(doc.Methods, &MethodInfo{Name: name})
+		}
+	}
+	for _, m := range doc.Methods {
+		if m.Params == nil {
+			// The spec requires params. Describe unknown parameters as a
+			// single optional value with an unconstrained schema.
+			m.Params = []*ContentDescriptor{{Name: "params", Schema: map[string]any{}}}
+		}
+	}
+	return doc
+}
@@ -66,8 +66,9 @@ Now we can start the server:
 	srv.Start(ch)
 
 The Start method does not block.  A server runs until its channel closes or it
-is stopped explicitly by calling srv.Stop(). To wait for the server to finish,
-call:
+is stopped explicitly by calling srv.Stop(). To stop the server gracefully,
+allowing requests already in progress to finish, call srv.Shutdown(ctx).  To
+wait for the server to finish, call:
 
 	err := srv.Wait()
 
@@ -124,6 +125,12 @@ To decode the result from a successful response, use its UnmarshalResult method:
 	   log.Fatalln("UnmarshalResult:", err)
 	}
 
+The generic [CallAs] and [BatchAs] functions combine these steps, and a
+[Method] value describes the parameter and result types of a method so that
+client and server code can share them:
+
+	sum, err := jrpc2.CallAs[int](ctx, cli, "Math.Add", []int{1, 3, 5, 7})
+
 To close a client and discard all its pending work, call cli.Close().
 
 # Notifications
@@ -200,15 +207,33 @@ These rules imply that the client cannot rely on the execution order of calls
 that overlap in time: If the caller needs to ensure that call A completes
 before call B starts, it must wait for A to return before invoking B.
 
+Alternatively, the server can enforce an order among related requests: If the
+OrderKey field of ServerOptions is set, requests that share an ordering key are
+executed one at a time in order of arrival, even if they are concurrent.
+
 # Built-in Methods
 
 Per the JSON-RPC 2.0 spec, method names beginning with "rpc." are reserved by
 the implementation. By default, a server does not dispatch these methods to its
-assigner. In this configuration, the server exports a "rpc.serverInfo" method
-taking no parameters and returning a jrpc2.ServerInfo value.
+assigner. In this configuration, the server exports these built-in methods:
+
+  - "rpc.serverInfo" takes no parameters and returns a jrpc2.ServerInfo value.
+  - "rpc.cancel" takes an array of request IDs and cancels the contexts of
+    the corresponding pending requests, as if by [Server.CancelRequest].
+  - "rpc.discover" takes no parameters and returns an OpenRPC document
+    describing the methods of the server (see [Server.Discover]).
+
+If the server has a [Hub], it also exports these built-in methods:
+
+  - "rpc.subscribe" takes an array of topic names, and subscribes the client
+    to messages published to those topics.
+  - "rpc.unsubscribe" takes an array of topic names, and removes the client's
+    subscriptions to those topics.
+
+Built-in methods are not subject to the Concurrency limit of the server.
 
 Setting the DisableBuiltin server option to true removes special treatment of
-"rpc." method names, and disables the rpc.serverInfo handler.  When this option
+"rpc." method names, and disables the built-in handlers.  When this option
 is true, method names beginning with "rpc." will be dispatched to the assigner
 like any other method.
 
@@ -236,6 +261,14 @@ Since not all clients support server push, handlers should set a timeout when
 using the server Callback method; otherwise the callback may block forever for
 a client response that will never arrive.
 
+A handler for a long-running call can use [ReportProgress] to send progress
+reports to the caller while the call is in progress.  The client delivers
+these to the OnProgress callback set for the call with [Client.CallWith].
+
+The method set of a running server can be replaced with [Server.SetAssigner].
+If the NotifyMethodsChanged server option is set, the server then sends an
+"rpc.methodsChanged" notification to the client listing the new methods.
+
 # Contexts and Cancellation
 
 Both the [Server] and the [Client] use the standard context package to plumb
@@ -250,14 +283,42 @@ On the client, the context passed to the Call and CallResult methods governs
 the lifetime of the call. If the context ends before the call is complete, the
 client will terminate the call and report an error.
 
-Note that cancellation on the client is not automatically propagated to the
-server, as JSON-RPC does not define a standard mechanism to do so.  One typical
-approach (used by LSP, for example) is to define a separate method on the
-server to handle cancellation requests.
+JSON-RPC does not define a standard mechanism to propagate cancellation from
+the client to the server.  By default, cancellation on the client is not
+propagated.  If the PropagateCancel option is set in the [ClientOptions], the
+client sends an "rpc.cancel" notification to the server when the context for a
+Call ends before the server has responded. A [Server] with built-in methods
+enabled handles this notification by cancelling the corresponding request.
 
 If an OnCancel hook is set in the [ClientOptions], the client calls it when the
 context for a Call ends before the server has responded.  This can be used to
-forward cancellation to the server separately.
+forward cancellation to a server that uses some other mechanism (as LSP does,
+for example).
+
+Likewise, deadlines are not propagated by default.  If the PropagateTimeouts
+option is set in both the [ClientOptions] and the [ServerOptions], the client
+sends the time remaining before the deadline of each call, and the server
+applies it to the context passed to the handler, subject to the MaxTimeout
+server option.  Callbacks from the server to the client are handled the same
+way.
+
+# Extension Fields
+
+Protocol extension fields are non-standard top-level fields of a request or
+response object, which carry metadata alongside the standard fields:
+
+	{"jsonrpc":"2.0", "id":1, "method":"M", "params":[], "meta":{"user":"alice"}}
+
+By default, a [Server] or [Client] rejects a message with extra fields as
+invalid.  To accept extension fields, list their keys in the Extensions field
+of the [ServerOptions] or [ClientOptions].  The values of accepted fields are
+available from the Extension methods of [Request] and [Response].
+
+To send extension fields with a request, attach them to the context of the
+call with [WithExtension], or set them in the Extensions field of a [Spec].  A
+handler can set extension fields on its response with [SetResponseExtension].
+The names of standard fields, and of fields used by built-in features such as
+"trace" and "timeout", cannot be used as extension keys.
 */
 package jrpc2
 
@@ -38,6 +38,10 @@ func (e *Error) WithData(v any) *Error {
 // an explicit call to its Stop method or orderly termination of its channel.
 var errServerStopped = errors.New("the server has been stopped")
 
+// errServerDrained is returned by Server.Wait when the server was shut down
+// by a call to its Shutdown method, and finished draining its requests.
+var errServerDrained = errors.New("the server has been shut down")
+
 // errClientStopped is the error reported when a client is shut down by an
 // explicit call to its Close method.
 var errClientStopped = errors.New("the client has been stopped")
@@ -57,6 +61,22 @@ var errInvalidRequest = &Error{Code: ParseError, Message: "invalid request value
 // errEmptyBatch is the error reported for an empty request batch.
 var errEmptyBatch = &Error{Code: InvalidRequest, Message: "empty request batch"}
 
+// errServerDraining is the error reported for a request received while the
+// server is draining for shutdown.
+var errServerDraining = &Error{Code: SystemError, Message: "server is shutting down"}
+
+// errMessageTooLarge is the error reported for a message that exceeds the
+// maximum message size of the server.
+var errMessageTooLarge = &Error{Code: InvalidRequest, Message: "message too large"}
+
+// errOverloaded is the error reported for a request received while the
+// server has too many requests pending.
+var errOverloaded = &Error{Code: Overloaded, Message: "too many pending requests"}
+
+// errPushDisabled is the error reported for a subscription request to a
+// server that does not allow push notifications.
+var errPushDisabled = &Error{Code: InvalidRequest, Message: ErrPushUnsupported.Error()}
+
 // errInvalidParams is the error reported for invalid request parameters.
 var errInvalidParams = &Error{Code: InvalidParams, Message: InvalidParams.String()}
 
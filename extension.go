@@ -0,0 +1,137 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jrpc2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/creachadair/mds/mctx"
+)
+
+// WithExtension returns a context derived from ctx that carries the extension
+// field key with the given value, in addition to any extension fields already
+// attached to ctx.  A [Client] attaches the extension fields from the context
+// of each call or notification to the request, and a [Server] attaches them to
+// each callback or notification pushed to the client.  The value must be
+// JSON-marshalable.
+//
+// The key must not be one of the standard JSON-RPC fields ("jsonrpc", "id",
+// "method", "params", "result", "error"), nor one of the fields used by the
+// built-in features of this package ("trace", "auth", "timeout",
+// "idempotencyKey"), which are set by the corresponding options and helpers.
+// A call or notification whose context carries such a key fails with an
+// error, without sending anything.
+func WithExtension(ctx context.Context, key string, value any) context.Context {
+	old := extKey.Lookup(ctx).Get()
+	ext := make(map[string]any, len(old)+1)
+	maps.Copy(ext, old)
+	ext[key] = value
+	return extKey.Attach(ctx, ext)
+}
+
+var extKey = mctx.New[map[string]any]("extensions")
+
+// standardKeys are the names of the standard fields of a JSON-RPC message.
+var standardKeys = []string{"jsonrpc", "id", "method", "params", "result", "error"}
+
+// builtinKeys are the names of the non-standard fields set by the built-in
+// features of this package.
+var builtinKeys = []string{traceKey, authKey, timeoutKey, idempotencyKeyField}
+
+// checkExtensionKey reports an error if key cannot be used as the name of an
+// extension field.
+func checkExtensionKey(key string) error {
+	if slices.Contains(standardKeys, key) {
+		return fmt.Errorf("extension key %q is a standard field", key)
+	} else if slices.Contains(builtinKeys, key) {
+		return fmt.Errorf("extension key %q is reserved for a built-in feature", key)
+	}
+	return nil
+}
+
+// mustCheckExtensionKeys panics if any of keys cannot be used as the name of
+// an extension field.
+func mustCheckExtensionKeys(keys []string) {
+	for _, key := range keys {
+		if err := checkExtensionKey(key); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// injectExtensions attaches the extension fields from ctx, and then those in
+// extra (if any), to msg.
+func injectExtensions(ctx context.Context, extra map[string]any, msg *jmessage) error {
+	for _, ext := range []map[string]any{extKey.Lookup(ctx).Get(), extra} {
+		for key, value := range ext {
+			if err := checkExtensionKey(key); err != nil {
+				return err
+			}
+			bits, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			if msg.X == nil {
+				msg.X = make(map[string]json.RawMessage)
+			}
+			msg.X[key] = bits
+		}
+	}
+	return nil
+}
+
+// Extension returns the value of the extension field key of r, as JSON, or
+// nil if r does not have that field.
+func (r *Request) Extension(key string) json.RawMessage { return r.ext[key] }
+
+// Extension returns the value of the extension field key of r, as JSON, or
+// nil if r does not have that field.
+func (r *Response) Extension(key string) json.RawMessage { return r.ext[key] }
+
+// SetResponseExtension sets the extension field key to value on the response
+// to the request whose handler has context ctx.  The value must be
+// JSON-marshalable.  It reports an error if ctx is not the context of a
+// handler invoked by a [Server], or if key is reserved (see [WithExtension]).
+//
+// The client must be configured to accept the extension field; otherwise it
+// will reject the response as invalid.
+func SetResponseExtension(ctx context.Context, key string, value any) error {
+	rx := respExtKey.Lookup(ctx).Get()
+	if rx == nil {
+		return errors.New("context does not have a response")
+	} else if err := checkExtensionKey(key); err != nil {
+		return err
+	}
+	bits, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	rx.mu.Lock()
+	defer rx.mu.Unlock()
+	if rx.x == nil {
+		rx.x = make(map[string]json.RawMessage)
+	}
+	rx.x[key] = bits
+	return nil
+}
+
+var respExtKey = mctx.New[*respExt]("response-extensions")
+
+// respExt collects the extension fields set by a handler for its response.
+type respExt struct {
+	mu sync.Mutex
+	x  map[string]json.RawMessage
+}
+
+// get returns the extension fields collected by rx.
+func (rx *respExt) get() map[string]json.RawMessage {
+	rx.mu.Lock()
+	defer rx.mu.Unlock()
+	return rx.x
+}
@@ -36,6 +36,71 @@ func (m Map) Names() []string {
 	return names
 }
 
+// Describe implements the optional jrpc2.Describer extension interface.
+// Since a handler does not describe itself, each method is described by name
+// only, with unknown parameters.  Use a [Methods] value to describe the
+// parameters and results of methods.
+func (m Map) Describe() []*jrpc2.MethodInfo {
+	var out []*jrpc2.MethodInfo
+	for _, name := range m.Names() {
+		out = append(out, &jrpc2.MethodInfo{Name: name, Params: unknownParams()})
+	}
+	return out
+}
+
+// A Method is a handler together with a description of the method it
+// handles, for use in a [Methods] assigner.
+type Method struct {
+	Handler jrpc2.Handler
+	Info    *jrpc2.MethodInfo // the Name field is ignored; nil means unknown
+}
+
+// NewMethod adapts fn to a handler as [New] does, and pairs it with a
+// description of its parameters and result.  Like New, NewMethod panics if
+// the type of fn does not have one of the accepted forms.
+func NewMethod(fn any) Method {
+	fi, err := Check(fn)
+	if err != nil {
+		panic(err)
+	}
+	return fi.Method()
+}
+
+// Methods is an implementation of the [jrpc2.Assigner] interface like [Map],
+// whose methods carry descriptions of their parameters and results for the
+// rpc.discover method.
+type Methods map[string]Method
+
+// Assign implements part of the jrpc2.Assigner interface.
+func (m Methods) Assign(_ context.Context, method string) jrpc2.Handler { return m[method].Handler }
+
+// Names implements the optional jrpc2.Namer extension interface.
+func (m Methods) Names() []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Describe implements the optional jrpc2.Describer extension interface.
+// Methods without an Info are described by name only, with unknown
+// parameters.
+func (m Methods) Describe() []*jrpc2.MethodInfo {
+	var out []*jrpc2.MethodInfo
+	for _, name := range m.Names() {
+		mi := &jrpc2.MethodInfo{Params: unknownParams()}
+		if info := m[name].Info; info != nil {
+			cp := *info
+			mi = &cp
+		}
+		mi.Name = name
+		out = append(out, mi)
+	}
+	return out
+}
+
 // A ServiceMap combines multiple assigners into one, permitting a server to
 // export multiple services under different names.
 type ServiceMap map[string]jrpc2.Assigner
@@ -72,6 +137,31 @@ func (m ServiceMap) Names() []string {
 	return all
 }
 
+// Describe implements the optional jrpc2.Describer extension interface.  The
+// methods of each service are described with names of the form
+// Service.Method. Services whose assigners implement [jrpc2.Namer] but not
+// [jrpc2.Describer] are described by name only, and other services are
+// omitted.
+func (m ServiceMap) Describe() []*jrpc2.MethodInfo {
+	var all []*jrpc2.MethodInfo
+	for svc, assigner := range m {
+		switch t := assigner.(type) {
+		case jrpc2.Describer:
+			for _, mi := range t.Describe() {
+				cp := *mi // N.B. do not modify the service's description
+				cp.Name = svc + "." + mi.Name
+				all = append(all, &cp)
+			}
+		case jrpc2.Namer:
+			for _, name := range t.Names() {
+				all = append(all, &jrpc2.MethodInfo{Name: svc + "." + name})
+			}
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
 // New adapts a function to a [jrpc2.Handler]. The concrete value of fn must be
 // function accepted by [Check]. The resulting handler will handle JSON
 // encoding and decoding, call fn, and report appropriate errors.
@@ -239,6 +329,12 @@ func (fi *FuncInfo) Wrap() jrpc2.Handler {
 	}
 }
 
+// Method returns the handler generated by Wrap for fi, together with a
+// description of its parameters and result.
+func (fi *FuncInfo) Method() Method {
+	return Method{Handler: fi.Wrap(), Info: fi.methodInfo("")}
+}
+
 // Check checks whether fn can serve as a [jrpc2.Handler].  The concrete value
 // of fn must be a function with one of the following type signature schemes,
 // for JSON-marshalable types X and Y:
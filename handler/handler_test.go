@@ -398,6 +398,84 @@ func TestServiceMap(t *testing.T) {
 	}
 }
 
+// Verify that Methods and ServiceMap describe their methods.
+func TestServiceMap_Describe(t *testing.T) {
+	type point struct {
+		X, Y int
+		Tag  string `json:"tag,omitempty"`
+	}
+	m := handler.ServiceMap{
+		"Math": handler.Methods{
+			"Add":  handler.NewMethod(func(_ context.Context, vs []int) int { return 0 }),
+			"Move": handler.NewMethod(func(_ context.Context, p *point) (bool, error) { return false, nil }),
+			"Pos":  posMethod(t, func(_ context.Context, a string, b float64) error { return nil }, "a", "b"),
+			"Raw":  {Handler: func(context.Context, *jrpc2.Request) (any, error) { return nil, nil }},
+		},
+		"Plain": handler.Map{
+			"Test": handler.New(func(context.Context) error { return nil }),
+		},
+		"Fixed":  fixedDescriber{{Name: "M"}},
+		"Opaque": testAssigner{},
+	}
+	m.Describe() // N.B. a second call must not rename the fixed descriptions again
+	bits, err := json.Marshal(m.Describe())
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var got any
+	if err := json.Unmarshal(bits, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	const want = `[
+  {"name": "Fixed.M", "params": null},
+  {"name": "Math.Add", "params": [
+     {"name": "params", "required": true, "schema": {"type": "array", "items": {"type": "integer"}}}
+   ], "result": {"name": "result", "schema": {"type": "integer"}}},
+  {"name": "Math.Move", "paramStructure": "either", "params": [
+     {"name": "X", "schema": {"type": "integer"}},
+     {"name": "Y", "schema": {"type": "integer"}},
+     {"name": "tag", "schema": {"type": "string"}}
+   ], "result": {"name": "result", "schema": {"type": "boolean"}}},
+  {"name": "Math.Pos", "paramStructure": "either", "x-strictFields": true, "params": [
+     {"name": "a", "schema": {"type": "string"}},
+     {"name": "b", "schema": {"type": "number"}}
+   ], "result": {"name": "result", "schema": {"type": "null"}}},
+  {"name": "Math.Raw", "params": [{"name": "params", "schema": {}}]},
+  {"name": "Plain.Test", "params": [{"name": "params", "schema": {}}]}
+]`
+	var wantv any
+	if err := json.Unmarshal([]byte(want), &wantv); err != nil {
+		t.Fatalf("Unmarshal want: %v", err)
+	}
+	if diff := cmp.Diff(wantv, got); diff != "" {
+		t.Errorf("Wrong description (-want, +got):\n%s", diff)
+	}
+}
+
+// posMethod returns a described handler for fn with the given positional
+// parameter names.
+func posMethod(t *testing.T, fn any, names ...string) handler.Method {
+	t.Helper()
+	fi, err := handler.Positional(fn, names...)
+	if err != nil {
+		t.Fatalf("Positional: unexpected error: %v", err)
+	}
+	return fi.Method()
+}
+
+// fixedDescriber is an assigner that reports the same descriptions each time.
+type fixedDescriber []*jrpc2.MethodInfo
+
+func (fixedDescriber) Assign(context.Context, string) jrpc2.Handler { return nil }
+
+func (f fixedDescriber) Describe() []*jrpc2.MethodInfo { return f }
+
+// testAssigner is an assigner that does not implement jrpc2.Namer.
+type testAssigner struct{}
+
+func (testAssigner) Assign(context.Context, string) jrpc2.Handler { return nil }
+
 // Verify that argument decoding works.
 func TestArgs(t *testing.T) {
 	type stuff struct {
@@ -0,0 +1,163 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package handler
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+)
+
+var (
+	rawType       = reflect.TypeFor[json.RawMessage]()
+	timeType      = reflect.TypeFor[time.Time]()
+	marshalerType = reflect.TypeFor[json.Marshaler]()
+	textType      = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// unknownParams describes parameters whose structure is not known, as a
+// single optional value with an unconstrained schema.
+func unknownParams() []*jrpc2.ContentDescriptor {
+	return []*jrpc2.ContentDescriptor{{Name: "params", Schema: map[string]any{}}}
+}
+
+// methodInfo constructs an OpenRPC method description for fi.
+//
+// A struct argument is described as one parameter per field, named as for
+// positional decoding. Any other argument is described as a single parameter
+// named "params" whose schema covers the entire parameter value.
+func (fi *FuncInfo) methodInfo(name string) *jrpc2.MethodInfo {
+	mi := &jrpc2.MethodInfo{
+		Name:         name,
+		Params:       []*jrpc2.ContentDescriptor{},
+		StrictFields: fi.strictFields,
+	}
+	if fi.Result != nil {
+		mi.Result = &jrpc2.ContentDescriptor{Name: "result", Schema: typeSchema(fi.Result)}
+	} else {
+		mi.Result = &jrpc2.ContentDescriptor{Name: "result", Schema: map[string]any{"type": "null"}}
+	}
+
+	switch arg := fi.Argument; {
+	case arg == nil:
+		// no parameters
+
+	case arg == reqType:
+		mi.Params = unknownParams()
+
+	case fi.posNames != nil:
+		st := arg
+		if st.Kind() == reflect.Pointer {
+			st = st.Elem()
+		}
+		props := structProps(st, make(map[reflect.Type]bool))
+		for _, name := range fi.posNames {
+			schema, ok := props[name]
+			if !ok {
+				schema = map[string]any{} // an omitted positional argument
+			}
+			mi.Params = append(mi.Params, &jrpc2.ContentDescriptor{
+				Name:   name,
+				Schema: schema,
+			})
+		}
+		mi.ParamStructure = "by-name"
+		if fi.allowArray {
+			mi.ParamStructure = "either"
+		}
+
+	default:
+		mi.Params = append(mi.Params, &jrpc2.ContentDescriptor{
+			Name:     "params",
+			Required: true,
+			Schema:   typeSchema(arg),
+		})
+	}
+	return mi
+}
+
+// typeSchema returns a JSON Schema describing the JSON encoding of values of
+// type t. The result is approximate: Types with custom JSON or text encodings,
+// and recursive types, are described by an empty (unconstrained) schema.
+func typeSchema(t reflect.Type) map[string]any {
+	return schemaOf(t, make(map[reflect.Type]bool))
+}
+
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawType:
+		return map[string]any{}
+	case t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType):
+		return map[string]any{}
+	case t.Implements(textType) || reflect.PointerTo(t).Implements(textType):
+		return map[string]any{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		s := map[string]any{"type": "array", "items": schemaOf(t.Elem(), seen)}
+		if t.Kind() == reflect.Array {
+			s["minItems"] = t.Len()
+			s["maxItems"] = t.Len()
+		}
+		return s
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]any{} // recursive type
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		return map[string]any{"type": "object", "properties": structProps(t, seen)}
+	}
+	return map[string]any{} // interfaces, and anything else we can't describe
+}
+
+// structProps returns the JSON Schema for each encoded field of the struct
+// type t, keyed by its JSON name.
+func structProps(t reflect.Type, seen map[reflect.Type]bool) map[string]any {
+	props := make(map[string]any)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.SplitN(tag, ",", 2)[0]; n != "" {
+				name = n
+			} else if f.Anonymous {
+				continue
+			}
+		} else if f.Anonymous {
+			continue // match structFieldNames
+		}
+		props[name] = schemaOf(f.Type, seen)
+	}
+	return props
+}
@@ -0,0 +1,236 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jrpc2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/creachadair/mds/mctx"
+)
+
+// idempotencyKeyField is the name of the non-standard request field that
+// carries the idempotency key of a call, when idempotency keys are enabled:
+//
+//	{"jsonrpc":"2.0", "id":1, "method":"M", "idempotencyKey":"a1b2c3"}
+const idempotencyKeyField = "idempotencyKey"
+
+// WithIdempotencyKey returns a context derived from ctx that carries the
+// idempotency key for a call.  A [Client] sends the key with each call issued
+// with this context.  A server with an IdempotencyStore replies to a call with
+// a key it has already completed by replaying the stored response, rather than
+// invoking the handler again (see ServerOptions.IdempotencyStore).
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return idemKey.Attach(ctx, key)
+}
+
+var idemKey = mctx.New[string]("idempotency-key")
+
+// injectIdempotencyKey attaches the idempotency key from ctx (if any) to msg.
+func injectIdempotencyKey(ctx context.Context, msg *jmessage) {
+	key := idemKey.Lookup(ctx).Get()
+	if key == "" {
+		return
+	}
+	bits, _ := json.Marshal(key) // N.B. cannot fail for a string
+	if msg.X == nil {
+		msg.X = make(map[string]json.RawMessage)
+	}
+	msg.X[idempotencyKeyField] = bits
+}
+
+// A StoredResult is the outcome of a call recorded in an [IdempotencyStore].
+// Exactly one of Result and Error is set.
+type StoredResult struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+// An IdempotencyStore records the results of completed calls by idempotency
+// key.  The keys passed to the store combine the method name, the credentials
+// of the request (if any), and the key sent by the client, so the same client
+// key may be used for different methods and by different callers.
+// An IdempotencyStore must be safe for concurrent use by multiple goroutines.
+type IdempotencyStore interface {
+	// Load returns the result stored for key, and reports whether it was
+	// found.
+	Load(ctx context.Context, key string) (*StoredResult, bool)
+
+	// Store records the result of a completed call with the given key.
+	Store(ctx context.Context, key string, res *StoredResult)
+}
+
+// MemoryStore is an in-memory implementation of the [IdempotencyStore]
+// interface, which retains each result for a fixed time after it is stored.
+type MemoryStore struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	last  time.Time // when expired entries were last removed
+	items map[string]memoryItem
+}
+
+type memoryItem struct {
+	res     *StoredResult
+	expires time.Time
+}
+
+// NewMemoryStore constructs a new empty MemoryStore that retains each result
+// for the given duration.  If ttl ≤ 0, results are retained for one hour.
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	return &MemoryStore{ttl: ttl, items: make(map[string]memoryItem)}
+}
+
+// Load implements part of the [IdempotencyStore] interface.
+func (m *MemoryStore) Load(_ context.Context, key string) (*StoredResult, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[key]
+	if !ok || !time.Now().Before(item.expires) {
+		return nil, false
+	}
+	return item.res, true
+}
+
+// Store implements part of the [IdempotencyStore] interface.
+func (m *MemoryStore) Store(_ context.Context, key string, res *StoredResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.last) >= m.ttl {
+		for k, item := range m.items {
+			if !now.Before(item.expires) {
+				delete(m.items, k)
+			}
+		}
+		m.last = now
+	}
+	m.items[key] = memoryItem{res: res, expires: now.Add(m.ttl)}
+}
+
+// Len reports the number of results stored in m, including any that have
+// expired but have not yet been removed.
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.items)
+}
+
+var _ IdempotencyStore = (*MemoryStore)(nil)
+
+// idempotency is an Interceptor that replays stored results for calls whose
+// idempotency keys have already been completed.
+type idempotency struct {
+	store IdempotencyStore
+	keyOf func(*Request) string
+	calls *keyedCalls // shared by all servers using the same options
+}
+
+// key returns the idempotency key for req, or "" if it does not have one.
+func (id idempotency) key(req *Request) string {
+	if id.keyOf != nil {
+		return id.keyOf(req)
+	}
+	var key string
+	if raw := req.ext[idempotencyKeyField]; raw != nil && json.Unmarshal(raw, &key) == nil {
+		return key
+	}
+	return ""
+}
+
+// intercept implements the Interceptor for id.
+func (id idempotency) intercept(ctx context.Context, req *Request, next Handler) (any, error) {
+	if req.IsNotification() {
+		return next(ctx, req)
+	}
+	ckey := id.key(req)
+	if ckey == "" {
+		return next(ctx, req)
+	}
+	skey := req.method + "\x00" + string(req.Credentials()) + "\x00" + ckey
+
+	// Claim the key before checking the store, so that a retry arriving while
+	// the original call is still in progress waits for its result.
+	release, err := id.calls.acquire(ctx, skey)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if res, ok := id.store.Load(ctx, skey); ok {
+		if srv := serverKey.Lookup(ctx).Get(); srv != nil {
+			srv.metrics.add(metricReplayed, 1)
+		}
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		return res.Result, nil
+	}
+
+	v, err := next(ctx, req)
+	var res StoredResult
+	if err == nil {
+		res.Result, err = json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+	} else if isContextError(err) {
+		return nil, err // N.B. do not record, so that a retry may succeed
+	} else if !errors.As(err, &res.Error) {
+		res.Error = &Error{Code: ErrorCode(err), Message: err.Error()}
+	}
+	id.store.Store(ctx, skey, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Result, nil
+}
+
+// keyedCalls tracks the calls in progress with idempotency keys.
+type keyedCalls struct {
+	mu   sync.Mutex
+	busy map[string]chan struct{} // closed when the call with the key is complete
+}
+
+// acquire claims key for a call, waiting for any other call holding it to
+// complete first.  The caller must call the returned function to release the
+// claim when the call is complete.  If ctx ends before key is claimed, acquire
+// reports the error from ctx.
+func (kc *keyedCalls) acquire(ctx context.Context, key string) (func(), error) {
+	for {
+		kc.mu.Lock()
+		done, ok := kc.busy[key]
+		if !ok {
+			done = make(chan struct{})
+			kc.busy[key] = done
+			kc.mu.Unlock()
+			return func() {
+				kc.mu.Lock()
+				defer kc.mu.Unlock()
+				delete(kc.busy, key)
+				close(done)
+			}, nil
+		}
+		kc.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-done:
+		}
+	}
+}
+
+// idempotencyKeys returns the extension keys to accept from the wire if
+// idempotency keys are enabled via the extension field.
+func idempotencyKeys(store IdempotencyStore, keyOf func(*Request) string) []string {
+	if store == nil || keyOf != nil {
+		return nil
+	}
+	return []string{idempotencyKeyField}
+}
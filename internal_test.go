@@ -8,9 +8,11 @@ package jrpc2
 import (
 	"context"
 	"encoding/json"
+	"math"
 	"reflect"
 	"testing"
 	"testing/synctest"
+	"time"
 
 	"github.com/creachadair/jrpc2/channel"
 	"github.com/google/go-cmp/cmp"
@@ -178,11 +180,11 @@ func TestClient_contextCancellation(t *testing.T) {
 		// Start a call that will hang around until a timer expires or an explicit
 		// cancellation is received.
 		ctx, cancel := context.WithCancel(t.Context())
-		req, err := c.req(ctx, "Hang", nil)
+		req, err := c.req(ctx, "Hang", nil, nil)
 		if err != nil {
 			t.Fatalf("c.req(Hang) failed: %v", err)
 		}
-		rsps, err := c.send(ctx, jmessages{req})
+		rsps, err := c.send(ctx, jmessages{req}, nil)
 		if err != nil {
 			t.Fatalf("c.send(Hang) failed: %v", err)
 		}
@@ -216,7 +218,7 @@ func TestServer_specialMethods(t *testing.T) {
 				return true, nil
 			}),
 		}, nil)
-		for _, name := range []string{rpcServerInfo, "donkeybait"} {
+		for _, name := range []string{rpcServerInfo, rpcCancel, rpcDiscover, "donkeybait"} {
 			if got := s.assignLocked(t.Context(), name); got == nil {
 				t.Errorf("s.assignLocked(%s): no method assigned", name)
 			}
@@ -238,7 +240,7 @@ func TestServer_disableBuiltinHook(t *testing.T) {
 		}, &ServerOptions{DisableBuiltin: true})
 
 		// With builtins disabled, the default rpc.* methods should not get assigned.
-		for _, name := range []string{rpcServerInfo} {
+		for _, name := range []string{rpcServerInfo, rpcCancel, rpcDiscover} {
 			if got := s.assignLocked(t.Context(), name); got != nil {
 				t.Errorf("s.assignLocked(%s): got %p, wanted nil", name, got)
 			}
@@ -318,3 +320,51 @@ func TestMarshalResponse(t *testing.T) {
 		}
 	}
 }
+
+// Verify that extension fields do not duplicate or override standard fields.
+func TestMessage_reservedExtensions(t *testing.T) {
+	msg := &jmessage{
+		ID: json.RawMessage("1"),
+		R:  json.RawMessage(`"real"`),
+		X: map[string]json.RawMessage{
+			"result": json.RawMessage(`"hijacked"`),
+			"method": json.RawMessage(`"Y"`),
+			"meta":   json.RawMessage(`true`),
+		},
+	}
+	got, err := msg.toJSON()
+	if err != nil {
+		t.Fatalf("toJSON: unexpected error: %v", err)
+	}
+	if want := `{"jsonrpc":"2.0","id":1,"result":"real","meta":true}`; string(got) != want {
+		t.Errorf("toJSON: got %#q, want %#q", got, want)
+	}
+}
+
+func TestTimeoutOf(t *testing.T) {
+	const maxDur = time.Duration(math.MaxInt64/time.Millisecond) * time.Millisecond
+	tests := []struct {
+		value string
+		limit time.Duration
+		want  time.Duration
+	}{
+		{"", 0, 0},
+		{`"bogus"`, 0, 0},
+		{"-5", 0, 0},
+		{"0", 0, 0},
+		{"1500", 0, 1500 * time.Millisecond},
+		{"1500", time.Second, time.Second},
+		{"9223372036854775807", 0, maxDur},
+		{"9223372036854775807", time.Minute, time.Minute},
+		{"9223372036855", 0, maxDur},
+	}
+	for _, test := range tests {
+		req := &Request{method: "M"}
+		if test.value != "" {
+			req.ext = map[string]json.RawMessage{timeoutKey: json.RawMessage(test.value)}
+		}
+		if got := timeoutOf(req, test.limit); got != test.want {
+			t.Errorf("timeoutOf(%s, %v): got %v, want %v", test.value, test.limit, got, test.want)
+		}
+	}
+}
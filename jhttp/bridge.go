@@ -37,6 +37,7 @@ import (
 type Bridge struct {
 	local    server.Local
 	parseReq func(*http.Request) ([]*jrpc2.ParsedRequest, error)
+	creds    func(*http.Request) any
 	getter   *Getter
 }
 
@@ -124,7 +125,7 @@ func (b Bridge) serveInternal(w http.ResponseWriter, req *http.Request) error {
 	}
 
 	if len(spec) != 0 {
-		rsps, err := b.local.Client.Batch(req.Context(), spec)
+		rsps, err := b.local.Client.Batch(withCredentials(req, b.creds), spec)
 		if err != nil {
 			return err
 		}
@@ -193,12 +194,14 @@ func NewBridge(mux jrpc2.Assigner, opts *BridgeOptions) Bridge {
 			Server: opts.serverOptions(),
 		}),
 		parseReq: opts.parseRequest(),
+		creds:    opts.credentials(),
 	}
 	if pget := opts.parseGETRequest(); pget != nil {
 		g := NewGetter(mux, &GetterOptions{
 			Client:       opts.clientOptions(),
 			Server:       opts.serverOptions(),
 			ParseRequest: pget,
+			Credentials:  opts.credentials(),
 		})
 		b.getter = &g
 	}
@@ -230,6 +233,12 @@ type BridgeOptions struct {
 	// parse function, and are not passed to a ParseRequest hook even if one is
 	// defined.
 	ParseGETRequest func(*http.Request) (string, any, error)
+
+	// If set, this function is called to extract credentials from each HTTP
+	// request, for example from its Authorization header.  A non-nil result
+	// is sent to the server with the requests (see jrpc2.WithCredentials),
+	// for use by the Authorize function of the server options.
+	Credentials func(*http.Request) any
 }
 
 func (o *BridgeOptions) clientOptions() *jrpc2.ClientOptions {
@@ -260,6 +269,13 @@ func (o *BridgeOptions) parseGETRequest() func(*http.Request) (string, any, erro
 	return o.ParseGETRequest
 }
 
+func (o *BridgeOptions) credentials() func(*http.Request) any {
+	if o == nil {
+		return nil
+	}
+	return o.Credentials
+}
+
 // marshalError encodes an error response for an invalid request.
 func marshalError(req *jrpc2.ParsedRequest) ([]byte, error) {
 	v, err := json.Marshal(req.Error)
@@ -3,6 +3,7 @@
 package jhttp
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -25,6 +26,7 @@ import (
 //	Condition               HTTP Status
 //	----------------------- -----------------------------------
 //	Parsing request         400 (Bad request)
+//	Not authorized          403 (Forbidden)
 //	Method not found        404 (Not found)
 //	(other errors)          500 (Internal server error)
 //
@@ -44,6 +46,7 @@ import (
 type Getter struct {
 	local    server.Local
 	parseReq func(*http.Request) (string, any, error)
+	creds    func(*http.Request) any
 }
 
 // NewGetter constructs a new Getter that starts a server on mux and dispatches
@@ -58,6 +61,7 @@ func NewGetter(mux jrpc2.Assigner, opts *GetterOptions) Getter {
 			Server: opts.serverOptions(),
 		}),
 		parseReq: opts.parseRequest(),
+		creds:    opts.credentials(),
 	}
 }
 
@@ -73,9 +77,12 @@ func (g Getter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 
 	var result json.RawMessage
-	if err := g.local.Client.CallResult(req.Context(), method, params, &result); err != nil {
+	ctx := withCredentials(req, g.creds)
+	if err := g.local.Client.CallResult(ctx, method, params, &result); err != nil {
 		var status int
 		switch jrpc2.ErrorCode(err) {
+		case jrpc2.Unauthorized:
+			status = http.StatusForbidden
 		case jrpc2.MethodNotFound:
 			status = http.StatusNotFound
 		default:
@@ -112,6 +119,12 @@ type GetterOptions struct {
 	// uses the URL path as the method name and the URL query as the method
 	// parameters.
 	ParseRequest func(*http.Request) (string, any, error)
+
+	// If set, this function is called to extract credentials from each HTTP
+	// request, for example from its Authorization header.  A non-nil result
+	// is sent to the server with the request (see jrpc2.WithCredentials), for
+	// use by the Authorize function of the server options.
+	Credentials func(*http.Request) any
 }
 
 func (o *GetterOptions) clientOptions() *jrpc2.ClientOptions {
@@ -135,6 +148,25 @@ func (o *GetterOptions) parseRequest() func(*http.Request) (string, any, error)
 	return o.ParseRequest
 }
 
+func (o *GetterOptions) credentials() func(*http.Request) any {
+	if o == nil {
+		return nil
+	}
+	return o.Credentials
+}
+
+// withCredentials returns the context of req, with the credentials reported
+// by creds attached if creds != nil.
+func withCredentials(req *http.Request, creds func(*http.Request) any) context.Context {
+	ctx := req.Context()
+	if creds == nil {
+		return ctx
+	} else if v := creds(req); v != nil {
+		return jrpc2.WithCredentials(ctx, v)
+	}
+	return ctx
+}
+
 func writeJSON(w http.ResponseWriter, code int, obj any) {
 	bits, err := json.Marshal(obj)
 	if err != nil {
@@ -317,6 +317,67 @@ func TestBridge_parseGETRequest(t *testing.T) {
 	})
 }
 
+func TestBridge_credentials(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		b := jhttp.NewBridge(testService, &jhttp.BridgeOptions{
+			Server: &jrpc2.ServerOptions{
+				Authorize: func(_ context.Context, req *jrpc2.Request) error {
+					var token string
+					json.Unmarshal(req.Credentials(), &token)
+					if token != "Bearer sesame" {
+						return errors.New("invalid token")
+					}
+					return nil
+				},
+			},
+			Credentials: func(req *http.Request) any {
+				if auth := req.Header.Get("Authorization"); auth != "" {
+					return auth
+				}
+				return nil
+			},
+		})
+		defer checkClose(t, b)
+		hsrv, hcli := mtest.NewHTTPServer(t, b)
+
+		post := func(auth string) string {
+			t.Helper()
+			req, err := http.NewRequest("POST", hsrv.URL,
+				strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"Test1","params":["a"]}`))
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			if auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			rsp, err := hcli.Do(req)
+			if err != nil {
+				t.Fatalf("POST request failed: %v", err)
+			}
+			defer rsp.Body.Close()
+			body, err := io.ReadAll(rsp.Body)
+			if err != nil {
+				t.Errorf("Reading POST body: %v", err)
+			}
+			return string(body)
+		}
+
+		tests := []struct {
+			auth, want string
+		}{
+			{"", `{"jsonrpc":"2.0","id":1,"error":{"code":-32093,"message":"invalid token"}}`},
+			{"Bearer wrong", `{"jsonrpc":"2.0","id":1,"error":{"code":-32093,"message":"invalid token"}}`},
+			{"Bearer sesame", `{"jsonrpc":"2.0","id":1,"result":1}`},
+		}
+		for _, test := range tests {
+			if got := post(test.auth); got != test.want {
+				t.Errorf("POST with auth %q: got %#q, want %#q", test.auth, got, test.want)
+			}
+		}
+	})
+}
+
 func TestChannel(t *testing.T) {
 	synctest.Test(t, func(t *testing.T) {
 		b := jhttp.NewBridge(testService, nil)
@@ -0,0 +1,150 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jhttp
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/jrpc2"
+)
+
+// A MetricsHandler is a [http.Handler] that serves JSON-RPC server metrics in
+// the Prometheus text exposition format (version 0.0.4), which is also
+// accepted by OpenMetrics scrapers.
+//
+// If Metrics is set, the handler exports its counters and gauges, and for
+// each method, the request count, error counts labelled by error code, and a
+// latency histogram.  Otherwise, the handler exports the integer and
+// floating-point values of the shared [jrpc2.ServerMetrics] map, which does
+// not have per-method detail.
+//
+// Exported metric names have the form <namespace>_<name>, where the
+// namespace is "jrpc2" unless Namespace is set.
+type MetricsHandler struct {
+	Metrics   *jrpc2.Metrics // if nil, use jrpc2.ServerMetrics
+	Namespace string         // if empty, use "jrpc2"
+}
+
+// gaugeNames are the server metrics that are gauges rather than counters.
+var gaugeNames = map[string]bool{
+	"servers_active":  true,
+	"requests_queued": true,
+}
+
+// ServeHTTP implements the required method of [http.Handler].
+func (h MetricsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if req.Method == http.MethodHead {
+		return
+	}
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	if h.Metrics == nil {
+		h.writeShared(bw)
+	} else {
+		h.writeMetrics(bw, h.Metrics.Snapshot())
+	}
+}
+
+func (h MetricsHandler) name(base string) string {
+	if h.Namespace == "" {
+		return "jrpc2_" + base
+	}
+	return h.Namespace + "_" + base
+}
+
+// writeHeader writes the TYPE line for the named metric.
+func writeHeader(w io.Writer, name, mtype string) {
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, mtype)
+}
+
+// writeCounter writes a single unlabelled counter or gauge.
+func (h MetricsHandler) writeCounter(w io.Writer, base, value string) {
+	name, mtype := h.name(base), "gauge"
+	if !gaugeNames[base] {
+		name, mtype = name+"_total", "counter"
+	}
+	writeHeader(w, name, mtype)
+	fmt.Fprintf(w, "%s %s\n", name, value)
+}
+
+func (h MetricsHandler) writeShared(w io.Writer) {
+	vals := make(map[string]string)
+	jrpc2.ServerMetrics().Do(func(kv expvar.KeyValue) {
+		switch kv.Value.(type) {
+		case *expvar.Int, *expvar.Float:
+			vals[sanitize(kv.Key)] = kv.Value.String()
+		}
+	})
+	for _, key := range slices.Sorted(maps.Keys(vals)) {
+		h.writeCounter(w, key, vals[key])
+	}
+}
+
+func (h MetricsHandler) writeMetrics(w io.Writer, snap *jrpc2.MetricsSnapshot) {
+	for _, key := range slices.Sorted(maps.Keys(snap.Counters)) {
+		h.writeCounter(w, sanitize(key), strconv.FormatInt(snap.Counters[key], 10))
+	}
+	if len(snap.Methods) == 0 {
+		return
+	}
+	methods := slices.Sorted(maps.Keys(snap.Methods))
+
+	reqs := h.name("method_requests_total")
+	writeHeader(w, reqs, "counter")
+	for _, m := range methods {
+		fmt.Fprintf(w, "%s{method=%s} %d\n", reqs, quote(m), snap.Methods[m].Requests)
+	}
+
+	errs := h.name("method_errors_total")
+	writeHeader(w, errs, "counter")
+	for _, m := range methods {
+		codes := snap.Methods[m].Codes
+		for _, c := range slices.Sorted(maps.Keys(codes)) {
+			fmt.Fprintf(w, "%s{method=%s,code=\"%d\"} %d\n", errs, quote(m), c, codes[c])
+		}
+	}
+
+	lat := h.name("method_latency_seconds")
+	writeHeader(w, lat, "histogram")
+	for _, m := range methods {
+		hist := snap.Methods[m].Latency
+		for i, b := range hist.Bounds {
+			fmt.Fprintf(w, "%s_bucket{method=%s,le=\"%s\"} %d\n", lat, quote(m), formatFloat(b), hist.Counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{method=%s,le=\"+Inf\"} %d\n", lat, quote(m), hist.Count)
+		fmt.Fprintf(w, "%s_sum{method=%s} %s\n", lat, quote(m), formatFloat(hist.Sum))
+		fmt.Fprintf(w, "%s_count{method=%s} %d\n", lat, quote(m), hist.Count)
+	}
+}
+
+func formatFloat(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+
+// quote renders s as a quoted label value.
+func quote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// sanitize replaces characters not permitted in a metric name with "_".
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jhttp_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/creachadair/jrpc2/jhttp"
+	"github.com/creachadair/jrpc2/server"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		metrics := jrpc2.NewMetrics()
+		loc := server.NewLocal(handler.Map{
+			"Test": handler.New(func(context.Context) error {
+				time.Sleep(2 * time.Millisecond)
+				return nil
+			}),
+			`Odd"Name`: handler.New(func(context.Context) error {
+				return jrpc2.Errorf(jrpc2.InvalidParams, "bad")
+			}),
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{Metrics: metrics},
+		})
+		defer loc.Close()
+
+		ctx := t.Context()
+		for range 2 {
+			loc.Client.Call(ctx, "Test", nil)
+		}
+		loc.Client.Call(ctx, `Odd"Name`, nil)
+
+		rec := httptest.NewRecorder()
+		jhttp.MetricsHandler{Metrics: metrics}.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Status: got %d, want %d", rec.Code, http.StatusOK)
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+			t.Errorf("Content-Type: got %q", ct)
+		}
+		got := rec.Body.String()
+		for _, want := range []string{
+			"# TYPE jrpc2_rpc_requests_total counter\njrpc2_rpc_requests_total 3\n",
+			"# TYPE jrpc2_servers_active gauge\njrpc2_servers_active 1\n",
+			`jrpc2_method_requests_total{method="Test"} 2`,
+			`jrpc2_method_requests_total{method="Odd\"Name"} 1`,
+			`jrpc2_method_errors_total{method="Odd\"Name",code="-32602"} 1`,
+			"# TYPE jrpc2_method_latency_seconds histogram\n",
+			`jrpc2_method_latency_seconds_bucket{method="Test",le="0.001"} 0`,
+			`jrpc2_method_latency_seconds_bucket{method="Test",le="0.005"} 2`,
+			`jrpc2_method_latency_seconds_bucket{method="Test",le="+Inf"} 2`,
+			`jrpc2_method_latency_seconds_count{method="Test"} 2`,
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("Output is missing %q", want)
+			}
+		}
+		if t.Failed() {
+			t.Logf("Output:\n%s", got)
+		}
+	})
+
+	t.Run("Shared", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		jhttp.MetricsHandler{Namespace: "test"}.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if got := rec.Body.String(); !strings.Contains(got, "# TYPE test_rpc_requests_total counter\n") {
+			t.Errorf("Output is missing rpc_requests:\n%s", got)
+		}
+	})
+}
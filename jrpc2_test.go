@@ -9,6 +9,7 @@ import (
 	"expvar"
 	"fmt"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -1329,3 +1330,1881 @@ func TestClient_IsStopped(t *testing.T) {
 		}
 	})
 }
+
+// Verify that server interceptors wrap handlers in the expected order, and can
+// observe and rewrite results, including for the built-in methods.
+func TestServer_interceptors(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var trace []string
+		tag := func(name string) jrpc2.Interceptor {
+			return func(ctx context.Context, req *jrpc2.Request, next jrpc2.Handler) (any, error) {
+				trace = append(trace, name+":"+req.Method())
+				v, err := next(ctx, req)
+				trace = append(trace, "/"+name)
+				return v, err
+			}
+		}
+		loc := server.NewLocal(handler.Map{
+			"OK": testOK,
+			"Fail": handler.New(func(context.Context) error {
+				return errors.New("unstructured failure")
+			}),
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{
+				Interceptors: []jrpc2.Interceptor{
+					tag("outer"),
+					tag("inner"),
+					func(ctx context.Context, req *jrpc2.Request, next jrpc2.Handler) (any, error) {
+						// Normalize errors to a fixed code.
+						v, err := next(ctx, req)
+						if err != nil {
+							return nil, jrpc2.Errorf(-32001, "normalized: %v", err)
+						}
+						return v, nil
+					},
+				},
+			},
+		})
+		defer loc.Close()
+		ctx := t.Context()
+
+		if got, err := loc.Client.Call(ctx, "OK", nil); err != nil {
+			t.Errorf("Call(OK) failed: %v", err)
+		} else if s := got.ResultString(); s != `"OK"` {
+			t.Errorf("Call(OK): got %s, want %q", s, "OK")
+		}
+		if _, err := loc.Client.Call(ctx, "Fail", nil); jrpc2.ErrorCode(err) != -32001 {
+			t.Errorf("Call(Fail): got %v, want code -32001", err)
+		}
+		if _, err := loc.Client.Call(ctx, "rpc.serverInfo", nil); err != nil {
+			t.Errorf("Call(rpc.serverInfo) failed: %v", err)
+		}
+
+		want := []string{
+			"outer:OK", "inner:OK", "/inner", "/outer",
+			"outer:Fail", "inner:Fail", "/inner", "/outer",
+			"outer:rpc.serverInfo", "inner:rpc.serverInfo", "/inner", "/outer",
+		}
+		if diff := cmp.Diff(want, trace); diff != "" {
+			t.Errorf("Interceptor trace (-want, +got):\n%s", diff)
+		}
+	})
+}
+
+// Verify that a server with RecoverPanics enabled reports a panicking handler
+// as an InternalError, and continues serving other requests.
+func TestServer_recoverPanics(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		loc := server.NewLocal(handler.Map{
+			"OK": testOK,
+			"Panic": handler.New(func(context.Context) error {
+				panic("the sky is falling")
+			}),
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{
+				RecoverPanics: true,
+				PanicStack:    true,
+				Concurrency:   2,
+			},
+		})
+		defer loc.Close()
+
+		before := jrpc2.ServerMetrics().Get("handler_panics").(*expvar.Int).Value()
+		rsps, err := loc.Client.Batch(t.Context(), []jrpc2.Spec{
+			{Method: "Panic"},
+			{Method: "OK"},
+		})
+		if err != nil {
+			t.Fatalf("Batch failed: %v", err)
+		}
+		if err := rsps[0].Error(); err == nil {
+			t.Error("Panic: got nil error, want InternalError")
+		} else if err.Code != jrpc2.InternalError {
+			t.Errorf("Panic: got code %v, want %v", err.Code, jrpc2.InternalError)
+		} else if len(err.Data) == 0 {
+			t.Error("Panic: error data does not include a stack trace")
+		}
+		if got := rsps[1].ResultString(); got != `"OK"` {
+			t.Errorf("OK: got %s, want %q", got, "OK")
+		}
+
+		// The connection should still be usable after the panic.
+		if _, err := loc.Client.Call(t.Context(), "OK", nil); err != nil {
+			t.Errorf("Call(OK) after panic failed: %v", err)
+		}
+		after := jrpc2.ServerMetrics().Get("handler_panics").(*expvar.Int).Value()
+		if after != before+1 {
+			t.Errorf("Metric handler_panics: got %d, want %d", after, before+1)
+		}
+	})
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	t.Run("Drained", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			release := make(chan struct{})
+			loc := server.NewLocal(handler.Map{
+				"OK": testOK,
+				"Wait": handler.New(func(ctx context.Context) (string, error) {
+					<-release
+					return "done", nil
+				}),
+			}, nil)
+			defer loc.Client.Close()
+
+			// Start a call that will block until released.
+			var rsp string
+			var rerr error
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				rerr = loc.Client.CallResult(t.Context(), "Wait", nil, &rsp)
+			}()
+			synctest.Wait()
+
+			// Begin shutting down. The server should wait for the pending call.
+			var serr error
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				serr = loc.Server.Shutdown(t.Context())
+			}()
+			synctest.Wait()
+
+			// New calls arriving while the server drains should be rejected.
+			if _, err := loc.Client.Call(t.Context(), "OK", nil); err == nil {
+				t.Error("Call(OK) during shutdown: got nil error, want rejection")
+			} else if got := jrpc2.ErrorCode(err); got != jrpc2.SystemError {
+				t.Errorf("Call(OK) during shutdown: got code %v, want %v", got, jrpc2.SystemError)
+			}
+
+			close(release)
+			<-done
+			<-stopped
+			if rerr != nil {
+				t.Errorf("Call(Wait) failed: %v", rerr)
+			} else if rsp != "done" {
+				t.Errorf("Call(Wait): got %q, want done", rsp)
+			}
+			if serr != nil {
+				t.Errorf("Shutdown: unexpected error: %v", serr)
+			}
+			stat := loc.Server.WaitStatus()
+			if !stat.Success() || !stat.Drained || stat.Stopped || stat.Closed {
+				t.Errorf("WaitStatus: got %+v, want drained", stat)
+			}
+		})
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			loc := server.NewLocal(handler.Map{
+				"Stall": handler.New(func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				}),
+			}, nil)
+			defer loc.Client.Close()
+
+			var cerr error
+			go func() { _, cerr = loc.Client.Call(t.Context(), "Stall", nil) }()
+			synctest.Wait()
+
+			ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+			defer cancel()
+			if err := loc.Server.Shutdown(ctx); err != context.DeadlineExceeded {
+				t.Errorf("Shutdown: got %v, want %v", err, context.DeadlineExceeded)
+			}
+			stat := loc.Server.WaitStatus()
+			if !stat.Success() || !stat.Stopped || stat.Drained {
+				t.Errorf("WaitStatus: got %+v, want stopped", stat)
+			}
+			synctest.Wait()
+			if cerr == nil {
+				t.Error("Call(Stall): got nil error, want cancellation")
+			}
+		})
+	})
+}
+
+// Verify that a server with a bounded queue rejects calls and drops
+// notifications that exceed the limit.
+func TestServer_maxQueuedRequests(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		release := make(chan struct{})
+		var notes atomic.Int32
+		loc := server.NewLocal(handler.Map{
+			"Wait": handler.New(func(context.Context) error {
+				<-release
+				return nil
+			}),
+			"Note": handler.New(func(context.Context) error {
+				notes.Add(1)
+				return nil
+			}),
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{
+				Concurrency:       1,
+				MaxQueuedRequests: 2,
+			},
+		})
+		defer loc.Close()
+		ctx := t.Context()
+
+		rejected := jrpc2.ServerMetrics().Get("requests_rejected").(*expvar.Int)
+		before := rejected.Value()
+
+		// Fill up the queue with two calls: One running and one waiting.
+		var wg sync.WaitGroup
+		for range 2 {
+			wg.Go(func() {
+				if _, err := loc.Client.Call(ctx, "Wait", nil); err != nil {
+					t.Errorf("Call(Wait) failed: %v", err)
+				}
+			})
+			synctest.Wait()
+		}
+
+		// A subsequent call should be rejected, and a notification dropped.
+		if _, err := loc.Client.Call(ctx, "Wait", nil); jrpc2.ErrorCode(err) != jrpc2.Overloaded {
+			t.Errorf("Call(Wait): got %v, want code %v", err, jrpc2.Overloaded)
+		}
+		if err := loc.Client.Notify(ctx, "Note", nil); err != nil {
+			t.Errorf("Notify(Note) failed: %v", err)
+		}
+		synctest.Wait()
+
+		close(release)
+		wg.Wait()
+		if n := notes.Load(); n != 0 {
+			t.Errorf("Note handler ran %d times, want 0", n)
+		}
+		if got := rejected.Value() - before; got != 2 {
+			t.Errorf("Metric requests_rejected: got %d more, want 2", got)
+		}
+
+		// With the queue drained, requests should be accepted again.
+		if err := loc.Client.Notify(ctx, "Note", nil); err != nil {
+			t.Errorf("Notify(Note) failed: %v", err)
+		}
+		if _, err := loc.Client.Call(ctx, "Wait", nil); err != nil {
+			t.Errorf("Call(Wait) failed: %v", err)
+		}
+		if n := notes.Load(); n != 1 {
+			t.Errorf("Note handler ran %d times, want 1", n)
+		}
+	})
+}
+
+// Verify that a client with PropagateCancel set notifies the server when the
+// context for a call ends, and that the server cancels the handler.
+func TestClient_propagateCancel(t *testing.T) {
+	requireCreds := func(_ context.Context, req *jrpc2.Request) error {
+		if string(req.Credentials()) != `"ok"` {
+			return errors.New("missing credentials")
+		}
+		return nil
+	}
+	tests := []struct {
+		name  string
+		authz func(context.Context, *jrpc2.Request) error
+	}{
+		{"Default", nil},
+
+		// The rpc.cancel notification does not carry credentials, but is not
+		// subject to authorization.
+		{"Authorize", requireCreds},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				stopped := make(chan error, 1)
+				loc := server.NewLocal(handler.Map{
+					"Stall": handler.New(func(ctx context.Context) error {
+						<-ctx.Done()
+						stopped <- ctx.Err()
+						return ctx.Err()
+					}),
+				}, &server.LocalOptions{
+					Server: &jrpc2.ServerOptions{Concurrency: 1, Authorize: test.authz},
+					Client: &jrpc2.ClientOptions{PropagateCancel: true},
+				})
+				defer loc.Close()
+
+				ctx, cancel := context.WithCancel(t.Context())
+				if test.authz != nil {
+					ctx = jrpc2.WithCredentials(ctx, "ok")
+				}
+				var cerr error
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					_, cerr = loc.Client.Call(ctx, "Stall", nil)
+				}()
+
+				// Wait until the handler is in progress, then cancel the call.
+				synctest.Wait()
+				cancel()
+				<-done
+				if got := jrpc2.ErrorCode(cerr); got != jrpc2.Cancelled {
+					t.Errorf("Stall: got %v (%v), want %v", cerr, got, jrpc2.Cancelled)
+				}
+
+				// The handler should see its context end, even though the rpc.cancel
+				// notification arrives while the only execution slot is occupied.
+				synctest.Wait()
+				select {
+				case err := <-stopped:
+					if err != context.Canceled {
+						t.Errorf("Handler context: got %v, want %v", err, context.Canceled)
+					}
+				default:
+					t.Error("Handler was not cancelled")
+				}
+			})
+		})
+	}
+}
+
+func TestRPCDiscover(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		info := jrpc2.DiscoveryInfo{Title: "Test API", Version: "1.2.3"}
+		loc := server.NewLocal(handler.Methods{
+			"Test": {Handler: testOK},
+			"Add":  handler.NewMethod(func(_ context.Context, vs []int) int { return 0 }),
+			"Raw":  {Handler: func(context.Context, *jrpc2.Request) (any, error) { return nil, nil }},
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{DiscoveryInfo: &info},
+		})
+		defer loc.Close()
+
+		var doc jrpc2.DiscoveryDoc
+		if err := loc.Client.CallResult(t.Context(), "rpc.discover", nil, &doc); err != nil {
+			t.Fatalf("rpc.discover call failed: %v", err)
+		}
+		if doc.OpenRPC != jrpc2.OpenRPCVersion {
+			t.Errorf("OpenRPC version: got %q, want %q", doc.OpenRPC, jrpc2.OpenRPCVersion)
+		}
+		if doc.Info != info {
+			t.Errorf("Info: got %+v, want %+v", doc.Info, info)
+		}
+		var names []string
+		for _, m := range doc.Methods {
+			names = append(names, m.Name)
+		}
+		if diff := cmp.Diff([]string{"Add", "Raw", "Test"}, names); diff != "" {
+			t.Errorf("Wrong method names: (-want, +got)\n%s", diff)
+		}
+		if add := doc.Methods[0]; len(add.Params) != 1 || add.Result == nil {
+			t.Errorf("Add description: got params %v, result %v", add.Params, add.Result)
+		}
+
+		// A handler without a description has unknown params, not an empty
+		// parameter list.
+		if raw := doc.Methods[1]; len(raw.Params) != 1 || raw.Params[0].Required {
+			t.Errorf("Raw description: got params %v, want one optional", raw.Params)
+		}
+	})
+}
+
+// Verify that servers record per-method metrics, and that servers sharing a
+// Metrics value report combined totals.
+func TestServer_Metrics(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		metrics := jrpc2.NewMetrics()
+		assigner := handler.Map{
+			"Slow": handler.New(func(context.Context) error {
+				time.Sleep(20 * time.Millisecond)
+				return nil
+			}),
+			"Fail": handler.New(func(context.Context) error {
+				return jrpc2.Errorf(jrpc2.InvalidParams, "bad")
+			}),
+		}
+		opts := &server.LocalOptions{Server: &jrpc2.ServerOptions{Metrics: metrics}}
+		loc1 := server.NewLocal(assigner, opts)
+		defer loc1.Close()
+		loc2 := server.NewLocal(assigner, opts)
+		defer loc2.Close()
+		ctx := t.Context()
+
+		for _, loc := range []server.Local{loc1, loc2} {
+			if _, err := loc.Client.Call(ctx, "Slow", nil); err != nil {
+				t.Errorf("Call(Slow) failed: %v", err)
+			}
+			if _, err := loc.Client.Call(ctx, "Fail", nil); err == nil {
+				t.Error("Call(Fail): got nil error, want error")
+			}
+			loc.Client.Call(ctx, "Nonesuch", nil) // not counted per method
+		}
+
+		var si jrpc2.ServerInfo
+		if err := loc1.Client.CallResult(ctx, "rpc.serverInfo", nil, &si); err != nil {
+			t.Fatalf("rpc.serverInfo call failed: %v", err)
+		}
+		if si.Stats == nil {
+			t.Fatal("rpc.serverInfo: missing stats")
+		}
+		if got, want := si.Stats.Counters["rpc_requests"], int64(7); got != want {
+			t.Errorf("Metric rpc_requests: got %d, want %d", got, want)
+		}
+		if got, want := si.Stats.Counters["servers_active"], int64(2); got != want {
+			t.Errorf("Metric servers_active: got %d, want %d", got, want)
+		}
+		if _, ok := si.Stats.Methods["Nonesuch"]; ok {
+			t.Error("Unexpected metrics for unknown method")
+		}
+
+		slow := si.Stats.Methods["Slow"]
+		if slow == nil || slow.Requests != 2 || slow.Errors != 0 {
+			t.Errorf("Slow metrics: got %+v, want 2 requests, 0 errors", slow)
+		} else if h := slow.Latency; h.Count != 2 || h.Counts[2] != 0 || h.Counts[3] != 2 {
+			t.Errorf("Slow latency: got %+v, want 2 values in (10ms, 50ms]", h)
+		}
+		fail := si.Stats.Methods["Fail"]
+		if fail == nil || fail.Requests != 2 || fail.Errors != 2 || fail.Codes[jrpc2.InvalidParams] != 2 {
+			t.Errorf("Fail metrics: got %+v, want 2 requests, 2 InvalidParams errors", fail)
+		}
+	})
+}
+
+// testTracer is a jrpc2.Tracer that propagates the trace context it finds in
+// the context, and records the spans it starts and ends.
+type testTracer struct {
+	mu    sync.Mutex
+	spans []string
+}
+
+type traceCtxKey struct{}
+
+func (t *testTracer) Inject(ctx context.Context) jrpc2.TraceContext {
+	tc, _ := ctx.Value(traceCtxKey{}).(jrpc2.TraceContext)
+	return tc
+}
+
+func (t *testTracer) Start(ctx context.Context, req *jrpc2.Request, tc jrpc2.TraceContext) (context.Context, func(error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = append(t.spans, fmt.Sprintf("start %s %s", req.Method(), tc.Parent))
+	return context.WithValue(ctx, traceCtxKey{}, tc), func(err error) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.spans = append(t.spans, fmt.Sprintf("end %s %v", req.Method(), err))
+	}
+}
+
+// Verify that trace context flows from a client call to the server handler,
+// and from a server callback back to the client callback handler.
+func TestTracer(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		stracer, ctracer := new(testTracer), new(testTracer)
+		loc := server.NewLocal(handler.Map{
+			"Test": handler.New(func(ctx context.Context) error {
+				_, err := jrpc2.ServerFromContext(ctx).Callback(ctx, "Back", nil)
+				return err
+			}),
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{AllowPush: true, Tracer: stracer},
+			Client: &jrpc2.ClientOptions{
+				Tracer: ctracer,
+				OnCallback: func(context.Context, *jrpc2.Request) (any, error) {
+					return nil, errors.New("bad")
+				},
+			},
+		})
+		defer loc.Close()
+
+		ctx := context.WithValue(t.Context(), traceCtxKey{}, jrpc2.TraceContext{Parent: "00-abc-def-01"})
+		if _, err := loc.Client.Call(ctx, "Test", nil); err == nil {
+			t.Error("Call(Test): got nil error, want error")
+		}
+
+		if diff := cmp.Diff([]string{
+			"start Test 00-abc-def-01",
+			"end Test [-32098] bad",
+		}, stracer.spans); diff != "" {
+			t.Errorf("Server spans (-want, +got):\n%s", diff)
+		}
+		if diff := cmp.Diff([]string{
+			"start Back 00-abc-def-01",
+			"end Back bad",
+		}, ctracer.spans); diff != "" {
+			t.Errorf("Client spans (-want, +got):\n%s", diff)
+		}
+	})
+
+	// A server without a tracer should reject requests with trace context.
+	t.Run("Disabled", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			loc := server.NewLocal(handler.Map{"Test": testOK}, &server.LocalOptions{
+				Client: &jrpc2.ClientOptions{Tracer: new(testTracer)},
+			})
+			defer loc.Close()
+
+			ctx := context.WithValue(t.Context(), traceCtxKey{}, jrpc2.TraceContext{Parent: "x"})
+			if _, err := loc.Client.Call(ctx, "Test", nil); jrpc2.ErrorCode(err) != jrpc2.InvalidRequest {
+				t.Errorf("Call(Test): got %v, want %v", err, jrpc2.InvalidRequest)
+			}
+			if _, err := loc.Client.Call(t.Context(), "Test", nil); err != nil {
+				t.Errorf("Call(Test) without trace: unexpected error: %v", err)
+			}
+		})
+	})
+}
+
+// Verify that a server enforces its rate limits.
+func TestServer_rateLimit(t *testing.T) {
+	assigner := handler.Map{"Test": testOK, "Free": testOK}
+
+	t.Run("Reject", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			loc := server.NewLocal(assigner, &server.LocalOptions{
+				Server: &jrpc2.ServerOptions{
+					RateLimit:        jrpc2.RateLimit{Rate: 1, Burst: 2},
+					MethodRateLimits: map[string]jrpc2.RateLimit{"Free": {Rate: 1000, Burst: 10}},
+				},
+			})
+			defer loc.Close()
+			ctx := t.Context()
+			limited := loc.Server.Metrics().Snapshot().Counters["requests_rate_limited"]
+
+			for i := range 2 {
+				if _, err := loc.Client.Call(ctx, "Test", nil); err != nil {
+					t.Errorf("Call %d: unexpected error: %v", i+1, err)
+				}
+			}
+			_, err := loc.Client.Call(ctx, "Test", nil)
+			var jerr *jrpc2.Error
+			if !errors.As(err, &jerr) || jerr.Code != jrpc2.RateLimited {
+				t.Fatalf("Call 3: got %v, want code %v", err, jrpc2.RateLimited)
+			}
+			var ra jrpc2.RetryAfter
+			if err := json.Unmarshal(jerr.Data, &ra); err != nil {
+				t.Errorf("Decoding error data: %v", err)
+			} else if ra.Seconds != 1 {
+				t.Errorf("Retry after: got %v, want 1", ra.Seconds)
+			}
+
+			// The method with its own limit is not affected.
+			for range 5 {
+				if _, err := loc.Client.Call(ctx, "Free", nil); err != nil {
+					t.Errorf("Call(Free): unexpected error: %v", err)
+				}
+			}
+
+			// After waiting, calls should succeed again.
+			time.Sleep(time.Second)
+			if _, err := loc.Client.Call(ctx, "Test", nil); err != nil {
+				t.Errorf("Call after wait: unexpected error: %v", err)
+			}
+			got := loc.Server.Metrics().Snapshot().Counters["requests_rate_limited"] - limited
+			if got != 1 {
+				t.Errorf("Metric requests_rate_limited: got %d more, want 1", got)
+			}
+		})
+	})
+
+	t.Run("Delay", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			loc := server.NewLocal(assigner, &server.LocalOptions{
+				Server: &jrpc2.ServerOptions{
+					RateLimit:       jrpc2.RateLimit{Rate: 2, Burst: 1},
+					RateLimitPolicy: jrpc2.DelayExcess,
+				},
+			})
+			defer loc.Close()
+
+			start := time.Now()
+			for i := range 3 {
+				if _, err := loc.Client.Call(t.Context(), "Test", nil); err != nil {
+					t.Errorf("Call %d: unexpected error: %v", i+1, err)
+				}
+			}
+			if got, want := time.Since(start), time.Second; got != want {
+				t.Errorf("Elapsed time: got %v, want %v", got, want)
+			}
+		})
+	})
+}
+
+// Verify that a server with a maximum message size rejects larger messages
+// without dropping the connection.
+func TestServer_maxMessageSize(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		srv, cli := channel.Direct()
+		s := jrpc2.NewServer(handler.Map{"X": testOK}, &jrpc2.ServerOptions{
+			MaxMessageSize: 64,
+		}).Start(srv)
+		defer func() {
+			cli.Close()
+			if err := s.Wait(); err != nil {
+				t.Errorf("Server wait: unexpected error %v", err)
+			}
+		}()
+
+		tests := []struct {
+			input, want string
+		}{
+			{`{"jsonrpc":"2.0", "id": 1, "method": "X", "params": ["` + strings.Repeat("x", 64) + `"]}`,
+				`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"message too large"}}`},
+			{`{"jsonrpc":"2.0", "id": 2, "method": "X"}`,
+				`{"jsonrpc":"2.0","id":2,"result":"OK"}`},
+		}
+		for _, test := range tests {
+			if err := cli.Send([]byte(test.input)); err != nil {
+				t.Fatalf("Send %#q failed: %v", test.input, err)
+			}
+			raw, err := cli.Recv()
+			if err != nil {
+				t.Fatalf("Recv failed: %v", err)
+			}
+			if got := string(raw); got != test.want {
+				t.Errorf("Response:\n got %#q\nwant %#q", got, test.want)
+			}
+		}
+	})
+}
+
+func TestServer_orderKey(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		type editReq struct {
+			Doc string `json:"doc"`
+			N   int    `json:"n"`
+		}
+		var mu sync.Mutex
+		var start = make(map[editReq]time.Duration)
+		base := time.Now()
+
+		loc := server.NewLocal(handler.Map{
+			"Edit": handler.New(func(ctx context.Context, req editReq) error {
+				mu.Lock()
+				start[req] = time.Since(base)
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				return nil
+			}),
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{
+				Concurrency: 4,
+				OrderKey: func(req *jrpc2.Request) string {
+					var p editReq
+					req.UnmarshalParams(&p)
+					return p.Doc
+				},
+			},
+		})
+		defer loc.Close()
+
+		// Edits to the same document run in order, one at a time. Edits to
+		// other documents (or with no document) run concurrently.
+		reqs := []editReq{{"a", 1}, {"a", 2}, {"b", 1}, {"a", 3}, {"b", 2}, {"", 1}, {"", 2}}
+		var specs []jrpc2.Spec
+		for _, req := range reqs {
+			specs = append(specs, jrpc2.Spec{Method: "Edit", Params: req})
+		}
+		rsps, err := loc.Client.Batch(t.Context(), specs)
+		if err != nil {
+			t.Fatalf("Batch failed: %v", err)
+		}
+		for i, rsp := range rsps {
+			if err := rsp.Error(); err != nil {
+				t.Errorf("Response %d: unexpected error: %v", i+1, err)
+			}
+		}
+
+		const ms = time.Millisecond
+		want := map[editReq]time.Duration{
+			{"a", 1}: 0, {"a", 2}: 10 * ms, {"a", 3}: 20 * ms,
+			{"b", 1}: 0, {"b", 2}: 10 * ms,
+			{"", 1}: 0, {"", 2}: 0,
+		}
+		if diff := cmp.Diff(want, start); diff != "" {
+			t.Errorf("Start times (-want, +got):\n%s", diff)
+		}
+	})
+}
+
+func TestHub(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		hub := jrpc2.NewHub(0)
+		opts := &jrpc2.ServerOptions{AllowPush: true, Hub: hub}
+
+		var mu sync.Mutex
+		got := make(map[string][]string) // :: client ⇒ [topic:params]
+		newLocal := func(name string) server.Local {
+			return server.NewLocal(handler.Map{"Test": testOK}, &server.LocalOptions{
+				Server: opts,
+				Client: &jrpc2.ClientOptions{
+					OnNotify: func(req *jrpc2.Request) {
+						mu.Lock()
+						defer mu.Unlock()
+						got[name] = append(got[name], req.Method()+":"+string(req.ParamString()))
+					},
+				},
+			})
+		}
+		a, b := newLocal("a"), newLocal("b")
+		defer b.Close()
+
+		ctx := t.Context()
+		mustCall := func(loc server.Local, method string, params any) {
+			t.Helper()
+			if _, err := loc.Client.Call(ctx, method, params); err != nil {
+				t.Fatalf("Call %s: unexpected error: %v", method, err)
+			}
+		}
+		mustPublish := func(topic string, payload any, want int) {
+			t.Helper()
+			n, err := hub.Publish(topic, payload)
+			if err != nil {
+				t.Fatalf("Publish %q: unexpected error: %v", topic, err)
+			} else if n != want {
+				t.Errorf("Publish %q: sent to %d subscribers, want %d", topic, n, want)
+			}
+			synctest.Wait()
+		}
+
+		mustCall(a, "rpc.subscribe", []string{"news"})
+		mustCall(b, "rpc.subscribe", []string{"news", "sports"})
+		if diff := cmp.Diff(map[string]int{"news": 2, "sports": 1}, a.Server.ServerInfo().Topics); diff != "" {
+			t.Errorf("Topics (-want, +got):\n%s", diff)
+		}
+
+		mustPublish("news", []int{1}, 2)
+		mustPublish("sports", nil, 1)
+		mustPublish("weather", []int{2}, 0)
+
+		// After unsubscribing, a client does not receive messages.
+		mustCall(b, "rpc.unsubscribe", []string{"news"})
+		mustPublish("news", []int{3}, 1)
+
+		// When a connection closes, its subscriptions are removed.
+		a.Close()
+		mustPublish("news", []int{4}, 0)
+		if diff := cmp.Diff(map[string]int{"sports": 1}, b.Server.ServerInfo().Topics); diff != "" {
+			t.Errorf("Topics (-want, +got):\n%s", diff)
+		}
+
+		want := map[string][]string{
+			"a": {"news:[1]", "news:[3]"},
+			"b": {"news:[1]", "sports:"},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Notifications (-want, +got):\n%s", diff)
+		}
+	})
+
+	t.Run("NoPush", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			loc := server.NewLocal(handler.Map{"Test": testOK}, &server.LocalOptions{
+				Server: &jrpc2.ServerOptions{Hub: jrpc2.NewHub(0)},
+			})
+			defer loc.Close()
+
+			_, err := loc.Client.Call(t.Context(), "rpc.subscribe", []string{"news"})
+			if got := jrpc2.ErrorCode(err); got != jrpc2.InvalidRequest {
+				t.Errorf("Subscribe: got %v, want code %v", err, jrpc2.InvalidRequest)
+			}
+		})
+	})
+
+	t.Run("NoHub", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			loc := server.NewLocal(handler.Map{"Test": testOK}, nil)
+			defer loc.Close()
+
+			_, err := loc.Client.Call(t.Context(), "rpc.subscribe", []string{"news"})
+			if got := jrpc2.ErrorCode(err); got != jrpc2.MethodNotFound {
+				t.Errorf("Subscribe: got %v, want code %v", err, jrpc2.MethodNotFound)
+			}
+		})
+	})
+}
+
+func TestSession(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		userKey := jrpc2.NewSessionKey[string]("user")
+		loc := server.NewLocal(handler.Map{
+			"Login": handler.New(func(ctx context.Context, user []string) error {
+				userKey.Set(jrpc2.SessionFromContext(ctx), user[0])
+				return nil
+			}),
+			"Whoami": handler.New(func(ctx context.Context) (string, error) {
+				user, ok := userKey.Get(jrpc2.SessionFromContext(ctx))
+				if !ok {
+					return "", errors.New("not logged in")
+				}
+				return user, nil
+			}),
+		}, nil)
+		ctx := t.Context()
+
+		if _, err := loc.Client.Call(ctx, "Whoami", nil); err == nil {
+			t.Error("Whoami before login: got nil error, want error")
+		}
+		if _, err := loc.Client.Call(ctx, "Login", []string{"alice"}); err != nil {
+			t.Fatalf("Login: unexpected error: %v", err)
+		}
+		var got string
+		if err := loc.Client.CallResult(ctx, "Whoami", nil, &got); err != nil {
+			t.Errorf("Whoami: unexpected error: %v", err)
+		} else if got != "alice" {
+			t.Errorf("Whoami: got %q, want alice", got)
+		}
+
+		// After the server stops, the session is closed but still readable.
+		loc.Close()
+		sess := loc.Server.WaitStatus().Session
+		if !sess.Closed() {
+			t.Error("Session is not closed after the server stopped")
+		}
+		sess.Set("other", true)
+		if _, ok := sess.Get("other"); ok {
+			t.Error("Set on a closed session had an effect")
+		}
+		if got, _ := userKey.Get(sess); got != "alice" {
+			t.Errorf("Session user: got %q, want alice", got)
+		}
+	})
+}
+
+func TestServer_authorize(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var calls atomic.Int32
+		loc := server.NewLocal(handler.Map{
+			"Public": handler.New(func(context.Context) string { calls.Add(1); return "public" }),
+			"Secret": handler.New(func(context.Context) string { calls.Add(1); return "secret" }),
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{
+				Authorize: func(ctx context.Context, req *jrpc2.Request) error {
+					if req.Method() != "Secret" {
+						return nil
+					}
+					var user string
+					json.Unmarshal(req.Credentials(), &user)
+					if user != "root" {
+						return jrpc2.Errorf(jrpc2.InvalidRequest, "access denied").WithData(user)
+					}
+					return nil
+				},
+			},
+		})
+		defer loc.Close()
+		ctx := t.Context()
+		denied := loc.Server.Metrics().Snapshot().Counters["requests_unauthorized"]
+
+		if _, err := loc.Client.Call(ctx, "Public", nil); err != nil {
+			t.Errorf("Call(Public): unexpected error: %v", err)
+		}
+		for _, user := range []any{nil, "alice"} {
+			_, err := loc.Client.Call(jrpc2.WithCredentials(ctx, user), "Secret", nil)
+			var jerr *jrpc2.Error
+			if !errors.As(err, &jerr) || jerr.Code != jrpc2.Unauthorized {
+				t.Errorf("Call(Secret) as %v: got %v, want code %v", user, err, jrpc2.Unauthorized)
+			} else if jerr.Message != "access denied" {
+				t.Errorf("Call(Secret) as %v: got message %q, want access denied", user, jerr.Message)
+			}
+		}
+		if _, err := loc.Client.Call(jrpc2.WithCredentials(ctx, "root"), "Secret", nil); err != nil {
+			t.Errorf("Call(Secret) as root: unexpected error: %v", err)
+		}
+
+		// The handler should not have run for the denied calls.
+		if got := calls.Load(); got != 2 {
+			t.Errorf("Handler calls: got %d, want 2", got)
+		}
+		if got := loc.Server.Metrics().Snapshot().Counters["requests_unauthorized"] - denied; got != 2 {
+			t.Errorf("Metric requests_unauthorized: got %d more, want 2", got)
+		}
+	})
+
+	// Without an Authorize function, the server rejects credentials.
+	t.Run("NoAuthorize", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			loc := server.NewLocal(handler.Map{"Test": testOK}, nil)
+			defer loc.Close()
+
+			_, err := loc.Client.Call(jrpc2.WithCredentials(t.Context(), "root"), "Test", nil)
+			if got := jrpc2.ErrorCode(err); got != jrpc2.InvalidRequest {
+				t.Errorf("Call: got %v, want code %v", err, jrpc2.InvalidRequest)
+			}
+		})
+	})
+}
+
+func TestExtensions(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var mu sync.Mutex
+		var pushed []string
+		loc := server.NewLocal(handler.Map{
+			"Echo": func(ctx context.Context, req *jrpc2.Request) (any, error) {
+				if err := jrpc2.SetResponseExtension(ctx, "meta", "reply"); err != nil {
+					return nil, err
+				}
+				return req.Extension("meta"), nil
+			},
+			"Push": func(ctx context.Context, req *jrpc2.Request) (any, error) {
+				srv := jrpc2.ServerFromContext(ctx)
+				pctx := jrpc2.WithExtension(ctx, "meta", "pushed")
+				if err := srv.Notify(pctx, "note", nil); err != nil {
+					return nil, err
+				}
+				rsp, err := srv.Callback(pctx, "call", nil)
+				if err != nil {
+					return nil, err
+				}
+				return rsp.ResultString(), nil
+			},
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{AllowPush: true, Extensions: []string{"meta"}},
+			Client: &jrpc2.ClientOptions{
+				Extensions: []string{"meta"},
+				OnNotify: func(req *jrpc2.Request) {
+					mu.Lock()
+					defer mu.Unlock()
+					pushed = append(pushed, req.Method()+"="+string(req.Extension("meta")))
+				},
+				OnCallback: func(ctx context.Context, req *jrpc2.Request) (any, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					pushed = append(pushed, req.Method()+"="+string(req.Extension("meta")))
+					return "ok", nil
+				},
+			},
+		})
+		defer loc.Close()
+		ctx := t.Context()
+
+		// A call carries extension fields from its context, and the handler can
+		// set extension fields on its response.
+		rsp, err := loc.Client.Call(jrpc2.WithExtension(ctx, "meta", map[string]string{"user": "alice"}), "Echo", nil)
+		if err != nil {
+			t.Fatalf("Call(Echo): unexpected error: %v", err)
+		}
+		if got, want := rsp.ResultString(), `{"user":"alice"}`; got != want {
+			t.Errorf("Call result: got %#q, want %#q", got, want)
+		}
+		if got, want := string(rsp.Extension("meta")), `"reply"`; got != want {
+			t.Errorf("Response extension: got %#q, want %#q", got, want)
+		}
+
+		// Batch specs may set their own extension fields.
+		rsps, err := loc.Client.Batch(jrpc2.WithExtension(ctx, "meta", "ctx"), []jrpc2.Spec{
+			{Method: "Echo"},
+			{Method: "Echo", Extensions: map[string]any{"meta": "spec"}},
+		})
+		if err != nil {
+			t.Fatalf("Batch: unexpected error: %v", err)
+		}
+		var got []string
+		for _, rsp := range rsps {
+			got = append(got, rsp.ResultString())
+		}
+		if diff := cmp.Diff([]string{`"ctx"`, `"spec"`}, got); diff != "" {
+			t.Errorf("Batch results (-want, +got):\n%s", diff)
+		}
+
+		// Server pushes carry extension fields from their context.
+		if _, err := loc.Client.Call(ctx, "Push", nil); err != nil {
+			t.Fatalf("Call(Push): unexpected error: %v", err)
+		}
+		sort.Strings(pushed)
+		if diff := cmp.Diff([]string{`call="pushed"`, `note="pushed"`}, pushed); diff != "" {
+			t.Errorf("Pushed requests (-want, +got):\n%s", diff)
+		}
+	})
+
+	// Standard and built-in field names cannot be used as extension keys.
+	t.Run("Reserved", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			var ran []string
+			var setErr error
+			loc := server.NewLocal(handler.Map{
+				"X": func(ctx context.Context, req *jrpc2.Request) (any, error) {
+					ran = append(ran, "X")
+					setErr = jrpc2.SetResponseExtension(ctx, "result", "hijacked")
+					return "real", nil
+				},
+				"Y": func(context.Context, *jrpc2.Request) (any, error) {
+					ran = append(ran, "Y")
+					return "wrong", nil
+				},
+			}, &server.LocalOptions{
+				Server: &jrpc2.ServerOptions{Extensions: []string{"meta"}},
+			})
+			defer loc.Close()
+			ctx := t.Context()
+
+			// Client to server: the request is not sent.
+			for _, key := range []string{"method", "id", "timeout", "idempotencyKey"} {
+				if _, err := loc.Client.Call(jrpc2.WithExtension(ctx, key, "Y"), "X", nil); err == nil {
+					t.Errorf("Call with extension %q: got nil error, want error", key)
+				}
+			}
+			if _, err := loc.Client.Batch(ctx, []jrpc2.Spec{
+				{Method: "X", Extensions: map[string]any{"params": 1}},
+			}); err == nil {
+				t.Error("Batch with extension params: got nil error, want error")
+			}
+			if len(ran) != 0 {
+				t.Errorf("Handlers ran for rejected requests: %v", ran)
+			}
+
+			// Server to client: the response is not overridden.
+			rsp, err := loc.Client.Call(ctx, "X", nil)
+			if err != nil {
+				t.Fatalf("Call(X): unexpected error: %v", err)
+			} else if got := rsp.ResultString(); got != `"real"` {
+				t.Errorf("Call(X): got result %s, want real", got)
+			}
+			if setErr == nil {
+				t.Error("SetResponseExtension(result): got nil error, want error")
+			}
+
+			mustPanic := func(name string, f func()) {
+				t.Helper()
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%s did not panic", name)
+					}
+				}()
+				f()
+			}
+			mustPanic("NewServer", func() {
+				jrpc2.NewServer(handler.Map{}, &jrpc2.ServerOptions{Extensions: []string{"params"}})
+			})
+			mustPanic("NewClient", func() {
+				jrpc2.NewClient(nil, &jrpc2.ClientOptions{Extensions: []string{"auth"}})
+			})
+		})
+	})
+
+	// Extension fields that are not enabled are rejected.
+	t.Run("Disabled", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			loc := server.NewLocal(handler.Map{"Test": testOK}, nil)
+			defer loc.Close()
+
+			_, err := loc.Client.Call(jrpc2.WithExtension(t.Context(), "meta", 1), "Test", nil)
+			if got := jrpc2.ErrorCode(err); got != jrpc2.InvalidRequest {
+				t.Errorf("Call: got %v, want code %v", err, jrpc2.InvalidRequest)
+			}
+		})
+	})
+}
+
+func TestPropagateTimeouts(t *testing.T) {
+	// budget reports the time remaining before the deadline of ctx, if any.
+	budget := func(ctx context.Context) time.Duration {
+		if dl, ok := ctx.Deadline(); ok {
+			return time.Until(dl)
+		}
+		return 0
+	}
+
+	synctest.Test(t, func(t *testing.T) {
+		var cbBudget time.Duration
+		var waitErr error
+		loc := server.NewLocal(handler.Map{
+			"Budget": handler.New(func(ctx context.Context) time.Duration {
+				return budget(ctx)
+			}),
+			"Wait": handler.New(func(ctx context.Context) error {
+				<-ctx.Done()
+				waitErr = ctx.Err()
+				return waitErr
+			}),
+			"Push": handler.New(func(ctx context.Context) error {
+				cctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+				defer cancel()
+				_, err := jrpc2.ServerFromContext(ctx).Callback(cctx, "cb", nil)
+				return err
+			}),
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{
+				AllowPush:         true,
+				PropagateTimeouts: true,
+				MaxTimeout:        time.Minute,
+			},
+			Client: &jrpc2.ClientOptions{
+				PropagateTimeouts: true,
+				OnCallback: func(ctx context.Context, req *jrpc2.Request) (any, error) {
+					cbBudget = budget(ctx)
+					return nil, nil
+				},
+			},
+		})
+		defer loc.Close()
+
+		call := func(d time.Duration, method string) (time.Duration, error) {
+			ctx := t.Context()
+			if d > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, d)
+				defer cancel()
+			}
+			var got time.Duration
+			err := loc.Client.CallResult(ctx, method, nil, &got)
+			return got, err
+		}
+
+		// The handler sees the caller's time budget, capped by MaxTimeout.
+		tests := []struct {
+			timeout, want time.Duration
+		}{
+			{0, 0},
+			{5 * time.Second, 5 * time.Second},
+			{time.Hour, time.Minute},
+		}
+		for _, test := range tests {
+			got, err := call(test.timeout, "Budget")
+			if err != nil {
+				t.Errorf("Call with timeout %v: unexpected error: %v", test.timeout, err)
+			} else if got != test.want {
+				t.Errorf("Call with timeout %v: handler budget %v, want %v", test.timeout, got, test.want)
+			}
+		}
+
+		// The handler context ends when the client gives up.
+		start := time.Now()
+		if _, err := call(2*time.Second, "Wait"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Call(Wait): got %v, want %v", err, context.DeadlineExceeded)
+		}
+		synctest.Wait()
+		if got := time.Since(start); got != 2*time.Second {
+			t.Errorf("Call(Wait): took %v, want 2s", got)
+		}
+		if !errors.Is(waitErr, context.DeadlineExceeded) {
+			t.Errorf("Handler context: got %v, want %v", waitErr, context.DeadlineExceeded)
+		}
+
+		// Callbacks propagate their time budget to the client.
+		if _, err := call(0, "Push"); err != nil {
+			t.Errorf("Call(Push): unexpected error: %v", err)
+		}
+		if cbBudget != 3*time.Second {
+			t.Errorf("Callback budget: got %v, want 3s", cbBudget)
+		}
+	})
+}
+
+func TestReportProgress(t *testing.T) {
+	mux := handler.Map{
+		"Job": handler.New(func(ctx context.Context, n []int) (string, error) {
+			for i := range n[0] {
+				if err := jrpc2.ReportProgress(ctx, map[string]int{"done": i + 1}); err != nil {
+					return "", err
+				}
+			}
+			return "finished", nil
+		}),
+	}
+	run := func(t *testing.T, allowPush bool, want []string) {
+		synctest.Test(t, func(t *testing.T) {
+			loc := server.NewLocal(mux, &server.LocalOptions{
+				Server: &jrpc2.ServerOptions{AllowPush: allowPush},
+			})
+			defer loc.Close()
+
+			var got []string
+			rsp, err := loc.Client.CallWith(t.Context(), "Job", []int{3}, &jrpc2.CallOptions{
+				OnProgress: func(v json.RawMessage) { got = append(got, string(v)) },
+			})
+			if err != nil {
+				t.Fatalf("CallWith: unexpected error: %v", err)
+			} else if rsp.ResultString() != `"finished"` {
+				t.Errorf("CallWith: got result %#q, want finished", rsp.ResultString())
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("Progress reports (-want, +got):\n%s", diff)
+			}
+
+			// A call without a progress callback discards the reports.
+			if _, err := loc.Client.Call(t.Context(), "Job", []int{2}); err != nil {
+				t.Errorf("Call: unexpected error: %v", err)
+			}
+		})
+	}
+	t.Run("Push", func(t *testing.T) {
+		run(t, true, []string{`{"done":1}`, `{"done":2}`, `{"done":3}`})
+	})
+	t.Run("NoPush", func(t *testing.T) { run(t, false, nil) })
+}
+
+func TestCache(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var calls atomic.Int32
+		release := make(chan struct{})
+		cache := jrpc2.NewCache(&jrpc2.CacheOptions{
+			Methods: map[string]time.Duration{"Get": 0, "Slow": 0, "Now": 0, "Flaky": 0},
+			TTL:     time.Minute,
+		})
+		flaky := make(chan struct{})
+		loc := server.NewLocal(handler.Map{
+			"Get": handler.New(func(_ context.Context, m map[string]int) int {
+				return int(calls.Add(1))
+			}),
+			"Slow": handler.New(func(context.Context) int {
+				<-release
+				return int(calls.Add(1))
+			}),
+			"Put": handler.New(func(ctx context.Context) error {
+				cache.InvalidateMethod("Get")
+				return nil
+			}),
+			"Now": handler.New(func(context.Context) int {
+				return int(calls.Add(1))
+			}),
+			"Flaky": handler.New(func(context.Context) (int, error) {
+				<-flaky
+				if n := calls.Add(1); n > 1 {
+					return int(n), nil
+				}
+				return 0, context.Canceled
+			}),
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{Cache: cache, Concurrency: 4},
+		})
+		defer loc.Close()
+		ctx := t.Context()
+
+		call := func(method, params string, want int) {
+			t.Helper()
+			var got int
+			var req any
+			if params != "" {
+				req = json.RawMessage(params)
+			}
+			if err := loc.Client.CallResult(ctx, method, req, &got); err != nil {
+				t.Errorf("Call(%s, %s): unexpected error: %v", method, params, err)
+			} else if got != want {
+				t.Errorf("Call(%s, %s): got %d, want %d", method, params, got, want)
+			}
+		}
+		counters := func() (hits, misses int64) {
+			c := loc.Server.Metrics().Snapshot().Counters
+			return c["cache_hits"], c["cache_misses"]
+		}
+
+		call("Get", `{"a":1,"b":2}`, 1)
+		call("Get", `{ "b":2, "a":1 }`, 1) // same parameters, different encoding
+		call("Get", `{"a":2}`, 2)
+		if hits, misses := counters(); hits != 1 || misses != 2 {
+			t.Errorf("Metrics: got %d hits, %d misses; want 1, 2", hits, misses)
+		}
+
+		// Invalidating a single result causes only that one to miss.
+		if err := cache.Invalidate("Get", map[string]int{"a": 2}); err != nil {
+			t.Fatalf("Invalidate: unexpected error: %v", err)
+		}
+		call("Get", `{"a":1,"b":2}`, 1)
+		call("Get", `{"a":2}`, 3)
+
+		// Invalidating the method causes all its results to miss.
+		call("Put", `null`, 0)
+		call("Get", `{"a":1,"b":2}`, 4)
+
+		// Cached results expire after the TTL.
+		time.Sleep(2 * time.Minute)
+		call("Get", `{"a":1,"b":2}`, 5)
+
+		// Concurrent calls with the same parameters share one invocation.
+		before := calls.Load()
+		var wg sync.WaitGroup
+		for range 3 {
+			wg.Go(func() { call("Slow", `null`, int(before+1)) })
+		}
+		synctest.Wait()
+		close(release)
+		wg.Wait()
+		if got := calls.Load() - before; got != 1 {
+			t.Errorf("Slow calls: got %d invocations, want 1", got)
+		}
+
+		// Missing and null parameters are equivalent, and are invalidated by
+		// passing nil parameters.
+		calls.Store(0)
+		call("Now", ``, 1)
+		call("Now", `null`, 1)
+		if err := cache.Invalidate("Now", nil); err != nil {
+			t.Fatalf("Invalidate: unexpected error: %v", err)
+		}
+		call("Now", ``, 2)
+
+		// A request waiting for a call that was cancelled does not share the
+		// cancellation, but retries the call itself.
+		calls.Store(0)
+		var results [2]int
+		var errs [2]error
+		for i := range 2 {
+			wg.Go(func() {
+				errs[i] = loc.Client.CallResult(ctx, "Flaky", nil, &results[i])
+			})
+		}
+		synctest.Wait()
+		close(flaky)
+		wg.Wait()
+		if got := calls.Load(); got != 2 {
+			t.Errorf("Flaky calls: got %d invocations, want 2", got)
+		}
+		if jrpc2.ErrorCode(errs[1]) == jrpc2.Cancelled {
+			errs[0], errs[1] = errs[1], errs[0]
+			results[0], results[1] = results[1], results[0]
+		}
+		if got := jrpc2.ErrorCode(errs[0]); got != jrpc2.Cancelled {
+			t.Errorf("Flaky: got %v, want one call cancelled", errs)
+		}
+		if errs[1] != nil || results[1] != 2 {
+			t.Errorf("Flaky retry: got %d, %v; want 2, nil", results[1], errs[1])
+		}
+	})
+}
+
+// Verify that cached results are not shared between callers with different
+// credentials, even among servers sharing a cache.
+func TestCache_credentials(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var calls atomic.Int32
+		cache := jrpc2.NewCache(&jrpc2.CacheOptions{Methods: map[string]time.Duration{"Who": 0}})
+		mux := handler.Map{
+			"Who": func(_ context.Context, req *jrpc2.Request) (any, error) {
+				calls.Add(1)
+				var who string
+				err := json.Unmarshal(req.Credentials(), &who)
+				return who, err
+			},
+		}
+		opts := &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{
+				Cache:     cache,
+				Authorize: func(context.Context, *jrpc2.Request) error { return nil },
+			},
+		}
+		loc1 := server.NewLocal(mux, opts)
+		defer loc1.Close()
+		loc2 := server.NewLocal(mux, opts)
+		defer loc2.Close()
+
+		call := func(cli *jrpc2.Client, who string) {
+			t.Helper()
+			ctx := jrpc2.WithCredentials(t.Context(), who)
+			var got string
+			if err := cli.CallResult(ctx, "Who", handler.Obj{"x": 1}, &got); err != nil {
+				t.Errorf("Call(Who) as %q: unexpected error: %v", who, err)
+			} else if got != who {
+				t.Errorf("Call(Who) as %q: got %q", who, got)
+			}
+		}
+		call(loc1.Client, "alice")
+		call(loc2.Client, "bob")
+		call(loc2.Client, "alice") // cached
+		call(loc1.Client, "bob")   // cached
+		if got := calls.Load(); got != 2 {
+			t.Errorf("Handler calls: got %d, want 2", got)
+		}
+
+		// Invalidation removes the results for all callers.
+		if err := cache.Invalidate("Who", handler.Obj{"x": 1}); err != nil {
+			t.Fatalf("Invalidate: unexpected error: %v", err)
+		}
+		if n := cache.Len(); n != 0 {
+			t.Errorf("Cache length after Invalidate: got %d, want 0", n)
+		}
+		call(loc1.Client, "alice")
+		call(loc1.Client, "bob")
+		if got := calls.Load(); got != 4 {
+			t.Errorf("Handler calls: got %d, want 4", got)
+		}
+	})
+}
+
+func TestServer_slowRequests(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var mu sync.Mutex
+		var logs []string
+		loc := server.NewLocal(handler.Map{
+			"Slow": handler.New(func(context.Context) string {
+				time.Sleep(2 * time.Second)
+				return "ok"
+			}),
+			"Hung": handler.New(func(ctx context.Context) string {
+				<-ctx.Done()
+				return "too late"
+			}),
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{
+				Logger: func(text string) {
+					mu.Lock()
+					defer mu.Unlock()
+					if strings.HasPrefix(text, "Slow request") {
+						logs = append(logs, text)
+					}
+				},
+				SlowRequestWarning: time.Second,
+				SlowRequestLimit:   5 * time.Second,
+			},
+		})
+		defer loc.Close()
+		ctx := t.Context()
+
+		if _, err := loc.Client.Call(ctx, "Slow", nil); err != nil {
+			t.Errorf("Call(Slow): unexpected error: %v", err)
+		}
+		mu.Lock()
+		if len(logs) != 1 {
+			t.Errorf("Got %d slow request logs, want 1", len(logs))
+		} else if !strings.Contains(logs[0], `method "Slow"`) || !strings.Contains(logs[0], "TestServer_slowRequests") {
+			t.Errorf("Slow request log is missing the method or handler stack:\n%s", logs[0])
+		}
+		mu.Unlock()
+
+		start := time.Now()
+		_, err := loc.Client.Call(ctx, "Hung", nil)
+		if got := jrpc2.ErrorCode(err); got != jrpc2.DeadlineExceeded {
+			t.Errorf("Call(Hung): got %v, want code %v", err, jrpc2.DeadlineExceeded)
+		}
+		if got := time.Since(start); got != 5*time.Second {
+			t.Errorf("Call(Hung): took %v, want 5s", got)
+		}
+
+		counters := loc.Server.Metrics().Snapshot().Counters
+		if got := counters["requests_slow"]; got != 2 {
+			t.Errorf("Metric requests_slow: got %d, want 2", got)
+		}
+		if got := counters["requests_timed_out"]; got != 1 {
+			t.Errorf("Metric requests_timed_out: got %d, want 1", got)
+		}
+	})
+}
+
+func TestIdempotency(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var calls atomic.Int32
+		mux := handler.Map{
+			"Add": handler.New(func(context.Context) int { return int(calls.Add(1)) }),
+			"Fail": handler.New(func(context.Context) error {
+				calls.Add(1)
+				return jrpc2.Errorf(jrpc2.Code(-1), "failed")
+			}),
+		}
+		opts := &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{IdempotencyStore: jrpc2.NewMemoryStore(time.Minute)},
+		}
+
+		// Two servers sharing options share the store, as a retry from a new
+		// connection would.
+		loc1 := server.NewLocal(mux, opts)
+		defer loc1.Close()
+		loc2 := server.NewLocal(mux, opts)
+		defer loc2.Close()
+
+		call := func(cli *jrpc2.Client, method, key string, want int) {
+			t.Helper()
+			ctx := t.Context()
+			if key != "" {
+				ctx = jrpc2.WithIdempotencyKey(ctx, key)
+			}
+			var got int
+			if err := cli.CallResult(ctx, method, nil, &got); err != nil {
+				t.Errorf("Call(%s, key %q): unexpected error: %v", method, key, err)
+			} else if got != want {
+				t.Errorf("Call(%s, key %q): got %d, want %d", method, key, got, want)
+			}
+		}
+		call(loc1.Client, "Add", "a", 1)
+		call(loc1.Client, "Add", "a", 1) // replayed
+		call(loc2.Client, "Add", "a", 1) // replayed from another server
+		call(loc2.Client, "Add", "b", 2)
+		call(loc1.Client, "Add", "", 3) // no key, not replayed
+		call(loc1.Client, "Add", "", 4)
+
+		// Errors are replayed also.
+		for range 2 {
+			_, err := loc1.Client.Call(jrpc2.WithIdempotencyKey(t.Context(), "a"), "Fail", nil)
+			if got := jrpc2.ErrorCode(err); got != jrpc2.Code(-1) {
+				t.Errorf("Call(Fail): got %v, want code -1", err)
+			}
+		}
+		if got := calls.Load(); got != 5 {
+			t.Errorf("Handler calls: got %d, want 5", got)
+		}
+		if got := loc1.Server.Metrics().Snapshot().Counters["requests_replayed"]; got != 2 {
+			t.Errorf("Metric requests_replayed: got %d, want 2", got)
+		}
+
+		// Stored results expire.
+		time.Sleep(2 * time.Minute)
+		call(loc1.Client, "Add", "a", 6)
+	})
+
+	// The key can be computed from the request instead.
+	t.Run("KeyFunc", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			var calls atomic.Int32
+			loc := server.NewLocal(handler.Map{
+				"Put": handler.New(func(ctx context.Context, req struct {
+					Key string `json:"key"`
+				}) int {
+					return int(calls.Add(1))
+				}),
+			}, &server.LocalOptions{
+				Server: &jrpc2.ServerOptions{
+					IdempotencyStore: jrpc2.NewMemoryStore(0),
+					IdempotencyKey: func(req *jrpc2.Request) string {
+						var p struct {
+							Key string `json:"key"`
+						}
+						req.UnmarshalParams(&p)
+						return p.Key
+					},
+				},
+			})
+			defer loc.Close()
+
+			for i, key := range []string{"x", "x", "y", "x"} {
+				var got int
+				if err := loc.Client.CallResult(t.Context(), "Put", handler.Obj{"key": key}, &got); err != nil {
+					t.Errorf("Call %d: unexpected error: %v", i+1, err)
+				}
+			}
+			if got := calls.Load(); got != 2 {
+				t.Errorf("Handler calls: got %d, want 2", got)
+			}
+
+			// Without the extension key enabled, the server rejects it.
+			_, err := loc.Client.Call(jrpc2.WithIdempotencyKey(t.Context(), "z"), "Put", handler.Obj{"key": "z"})
+			if got := jrpc2.ErrorCode(err); got != jrpc2.InvalidRequest {
+				t.Errorf("Call with key field: got %v, want code %v", err, jrpc2.InvalidRequest)
+			}
+		})
+	})
+
+	// A retry that arrives while the original call is in progress waits for
+	// and replays its result.
+	t.Run("Concurrent", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			var calls atomic.Int32
+			release := make(chan struct{})
+			loc := server.NewLocal(handler.Map{
+				"Slow": handler.New(func(context.Context) int {
+					<-release
+					return int(calls.Add(1))
+				}),
+			}, &server.LocalOptions{
+				Server: &jrpc2.ServerOptions{
+					IdempotencyStore: jrpc2.NewMemoryStore(0),
+					Concurrency:      4,
+				},
+			})
+			defer loc.Close()
+			ctx := jrpc2.WithIdempotencyKey(t.Context(), "k")
+
+			var wg sync.WaitGroup
+			for range 3 {
+				wg.Go(func() {
+					var got int
+					if err := loc.Client.CallResult(ctx, "Slow", nil, &got); err != nil || got != 1 {
+						t.Errorf("Call(Slow): got %d, %v; want 1, nil", got, err)
+					}
+				})
+			}
+			synctest.Wait()
+			close(release)
+			wg.Wait()
+			if got := calls.Load(); got != 1 {
+				t.Errorf("Handler calls: got %d, want 1", got)
+			}
+		})
+	})
+
+	// A call cancelled because its connection dropped is not recorded, so a
+	// retry on a new connection runs the handler.
+	t.Run("Cancelled", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			var calls atomic.Int32
+			mux := handler.Map{
+				"Put": handler.New(func(ctx context.Context) (int, error) {
+					if calls.Add(1) == 1 {
+						<-ctx.Done()
+						return 0, ctx.Err()
+					}
+					return int(calls.Load()), nil
+				}),
+			}
+			// N.B. The store need not be comparable.
+			store := uncomparableStore{MemoryStore: jrpc2.NewMemoryStore(0)}
+			opts := &server.LocalOptions{
+				Server: &jrpc2.ServerOptions{IdempotencyStore: store},
+			}
+			ctx := jrpc2.WithIdempotencyKey(t.Context(), "k")
+
+			loc1 := server.NewLocal(mux, opts)
+			done := make(chan error, 1)
+			go func() { _, err := loc1.Client.Call(ctx, "Put", nil); done <- err }()
+			synctest.Wait()
+			loc1.Server.Stop()
+			if err := <-done; err == nil {
+				t.Error("Call(Put) on stopped server: got nil error, want error")
+			}
+			loc1.Close()
+
+			loc2 := server.NewLocal(mux, opts)
+			defer loc2.Close()
+			var got int
+			if err := loc2.Client.CallResult(ctx, "Put", nil, &got); err != nil || got != 2 {
+				t.Errorf("Call(Put) retry: got %d, %v; want 2, nil", got, err)
+			}
+		})
+	})
+
+	// Callers with different credentials do not share results.
+	t.Run("Credentials", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			var calls atomic.Int32
+			loc := server.NewLocal(handler.Map{
+				"Add": handler.New(func(context.Context) int { return int(calls.Add(1)) }),
+			}, &server.LocalOptions{
+				Server: &jrpc2.ServerOptions{
+					IdempotencyStore: jrpc2.NewMemoryStore(0),
+					Authorize:        func(context.Context, *jrpc2.Request) error { return nil },
+				},
+			})
+			defer loc.Close()
+
+			for _, test := range []struct {
+				creds string
+				want  int
+			}{
+				{"alice", 1},
+				{"bob", 2},
+				{"alice", 1},
+				{"", 3},
+				{"bob", 2},
+			} {
+				ctx := jrpc2.WithIdempotencyKey(t.Context(), "k")
+				if test.creds != "" {
+					ctx = jrpc2.WithCredentials(ctx, test.creds)
+				}
+				var got int
+				if err := loc.Client.CallResult(ctx, "Add", nil, &got); err != nil {
+					t.Errorf("Call(Add, %q): unexpected error: %v", test.creds, err)
+				} else if got != test.want {
+					t.Errorf("Call(Add, %q): got %d, want %d", test.creds, got, test.want)
+				}
+			}
+		})
+	})
+}
+
+// uncomparableStore is an IdempotencyStore whose values are not comparable.
+type uncomparableStore struct {
+	*jrpc2.MemoryStore
+	_ []string
+}
+
+func TestServer_setAssigner(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		release := make(chan struct{})
+		notes := make(chan *jrpc2.Request, 1)
+		loc := server.NewLocal(handler.Map{
+			"Old": handler.New(func(context.Context) string { <-release; return "old" }),
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{AllowPush: true, NotifyMethodsChanged: true},
+			Client: &jrpc2.ClientOptions{OnNotify: func(req *jrpc2.Request) { notes <- req }},
+		})
+		defer loc.Close()
+		ctx := t.Context()
+
+		// Start a call on the old assigner, and swap while it is in flight.
+		done := make(chan error, 1)
+		go func() {
+			rsp, err := loc.Client.Call(ctx, "Old", nil)
+			if err == nil && rsp.ResultString() != `"old"` {
+				err = fmt.Errorf("got result %s, want old", rsp.ResultString())
+			}
+			done <- err
+		}()
+		synctest.Wait()
+		loc.Server.SetAssigner(handler.Map{"New": handler.New(func(context.Context) string { return "new" })})
+		close(release)
+		if err := <-done; err != nil {
+			t.Errorf("Call(Old) in flight: %v", err)
+		}
+
+		if _, err := loc.Client.Call(ctx, "Old", nil); jrpc2.ErrorCode(err) != jrpc2.MethodNotFound {
+			t.Errorf("Call(Old): got %v, want code %v", err, jrpc2.MethodNotFound)
+		}
+		if _, err := loc.Client.Call(ctx, "New", nil); err != nil {
+			t.Errorf("Call(New): unexpected error: %v", err)
+		}
+		if diff := cmp.Diff([]string{"New"}, loc.Server.ServerInfo().Methods); diff != "" {
+			t.Errorf("ServerInfo methods (-want, +got):\n%s", diff)
+		}
+
+		select {
+		case req := <-notes:
+			if req.Method() != "rpc.methodsChanged" || req.ParamString() != `{"methods":["New"]}` {
+				t.Errorf("Notification: got %s %s, want rpc.methodsChanged", req.Method(), req.ParamString())
+			}
+		default:
+			t.Error("Did not receive an rpc.methodsChanged notification")
+		}
+	})
+}
+
+func TestServer_callbackBatch(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		notes := make(chan string, 1)
+		loc := server.NewLocal(handler.Map{
+			"Ask": handler.New(func(ctx context.Context) ([]string, error) {
+				rsps, err := jrpc2.ServerFromContext(ctx).CallbackBatch(ctx, []jrpc2.Spec{
+					{Method: "Echo", Params: []string{"a"}},
+					{Method: "Note", Notify: true},
+					{Method: "Fail"},
+					{Method: "Echo", Params: []string{"b"}},
+				})
+				if err != nil {
+					return nil, err
+				}
+				var got []string
+				for _, rsp := range rsps {
+					if err := rsp.Error(); err != nil {
+						got = append(got, "error: "+err.Message)
+					} else {
+						got = append(got, rsp.ResultString())
+					}
+				}
+				return got, nil
+			}),
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{AllowPush: true},
+			Client: &jrpc2.ClientOptions{
+				OnNotify: func(req *jrpc2.Request) { notes <- req.Method() },
+				OnCallback: func(ctx context.Context, req *jrpc2.Request) (any, error) {
+					if req.Method() == "Fail" {
+						return nil, errors.New("failed")
+					}
+					var args []string
+					if err := req.UnmarshalParams(&args); err != nil {
+						return nil, err
+					}
+					return args[0], nil
+				},
+			},
+		})
+		defer loc.Close()
+
+		var got []string
+		if err := loc.Client.CallResult(t.Context(), "Ask", nil, &got); err != nil {
+			t.Fatalf("Call(Ask): unexpected error: %v", err)
+		}
+		if diff := cmp.Diff([]string{`"a"`, "error: failed", `"b"`}, got); diff != "" {
+			t.Errorf("Callback responses (-want, +got):\n%s", diff)
+		}
+		if got := <-notes; got != "Note" {
+			t.Errorf("Notification: got %q, want Note", got)
+		}
+	})
+
+	t.Run("NoPush", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			srv := jrpc2.NewServer(handler.Map{}, nil)
+			if _, err := srv.CallbackBatch(t.Context(), []jrpc2.Spec{{Method: "X"}}); err != jrpc2.ErrPushUnsupported {
+				t.Errorf("CallbackBatch: got %v, want %v", err, jrpc2.ErrPushUnsupported)
+			}
+		})
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			srv := jrpc2.NewServer(handler.Map{}, &jrpc2.ServerOptions{AllowPush: true})
+			if _, err := srv.CallbackBatch(t.Context(), []jrpc2.Spec{{Method: "X"}}); err != jrpc2.ErrConnClosed {
+				t.Errorf("CallbackBatch: got %v, want %v", err, jrpc2.ErrConnClosed)
+			}
+		})
+	})
+}
+
+func TestTypedCalls(t *testing.T) {
+	type point struct {
+		X, Y int
+	}
+	var (
+		add  = jrpc2.Method[[]int, int]{Name: "Add"}
+		flip = jrpc2.Method[point, point]{Name: "Flip"}
+		note = jrpc2.Method[point, any]{Name: "Note"}
+	)
+	synctest.Test(t, func(t *testing.T) {
+		notes := make(chan point, 1)
+		loc := server.NewLocal(handler.Map{
+			add.Name: add.Handler(func(_ context.Context, vs []int) (int, error) {
+				sum := 0
+				for _, v := range vs {
+					sum += v
+				}
+				return sum, nil
+			}),
+			flip.Name: flip.Handler(func(_ context.Context, p point) (point, error) {
+				return point{X: p.Y, Y: p.X}, nil
+			}),
+			note.Name: note.Handler(func(_ context.Context, p point) (any, error) {
+				notes <- p
+				return nil, nil
+			}),
+			"Fail": handler.New(func(context.Context) error {
+				return jrpc2.Errorf(jrpc2.Code(-1), "failed")
+			}),
+		}, nil)
+		defer loc.Close()
+		ctx := t.Context()
+
+		if got, err := jrpc2.CallAs[int](ctx, loc.Client, "Add", []int{1, 2, 3}); err != nil || got != 6 {
+			t.Errorf("CallAs(Add): got %v, %v; want 6, nil", got, err)
+		}
+		if got, err := flip.Call(ctx, loc.Client, point{X: 1, Y: 2}); err != nil || got != (point{X: 2, Y: 1}) {
+			t.Errorf("Call(Flip): got %+v, %v; want {2 1}, nil", got, err)
+		}
+		if _, err := jrpc2.CallAs[int](ctx, loc.Client, "Fail", nil); !errors.As(err, new(*jrpc2.Error)) {
+			t.Errorf("CallAs(Fail): got %v, want *jrpc2.Error", err)
+		}
+
+		// Without parameters, the handler receives the zero value.
+		if got, err := jrpc2.CallAs[point](ctx, loc.Client, "Flip", nil); err != nil || got != (point{}) {
+			t.Errorf("CallAs(Flip, nil): got %+v, %v; want {0 0}, nil", got, err)
+		}
+
+		if err := note.Notify(ctx, loc.Client, point{X: 5, Y: 6}); err != nil {
+			t.Errorf("Notify(Note): unexpected error: %v", err)
+		} else if got := <-notes; got != (point{X: 5, Y: 6}) {
+			t.Errorf("Notify(Note): handler got %+v, want {5 6}", got)
+		}
+
+		// Parameters of the wrong type are rejected by the handler.
+		if _, err := jrpc2.CallAs[int](ctx, loc.Client, "Add", handler.Obj{"x": 1}); jrpc2.ErrorCode(err) != jrpc2.InvalidParams {
+			t.Errorf("CallAs(Add, object): got %v, want code %v", err, jrpc2.InvalidParams)
+		}
+
+		sum := add.Batch([]int{4, 5})
+		pt := flip.Batch(point{X: 3, Y: 4})
+		fail := &jrpc2.TypedCall[string]{Method: "Fail"}
+		if err := jrpc2.BatchAs(ctx, loc.Client, sum, pt, fail); err != nil {
+			t.Fatalf("BatchAs: unexpected error: %v", err)
+		}
+		if sum.Err != nil || sum.Result != 9 {
+			t.Errorf("Batch Add: got %v, %v; want 9, nil", sum.Result, sum.Err)
+		}
+		if pt.Err != nil || pt.Result != (point{X: 4, Y: 3}) {
+			t.Errorf("Batch Flip: got %+v, %v; want {4 3}, nil", pt.Result, pt.Err)
+		}
+		if fail.Err == nil {
+			t.Error("Batch Fail: got nil error, want error")
+		}
+
+		// Nil calls are rejected without sending the batch.
+		for _, nc := range []jrpc2.BatchCall{nil, (*jrpc2.TypedCall[int])(nil)} {
+			if err := jrpc2.BatchAs(ctx, loc.Client, add.Batch(nil), nc); err == nil {
+				t.Errorf("BatchAs(%#v): got nil error, want error", nc)
+			}
+		}
+	})
+}
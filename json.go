@@ -5,6 +5,8 @@ package jrpc2
 import (
 	"bytes"
 	"encoding/json"
+	"maps"
+	"slices"
 )
 
 // ParseRequests parses either a single request or a batch of requests from
@@ -84,7 +86,10 @@ func (j jmessages) toJSON() ([]byte, error) {
 
 // N.B. Not UnmarshalJSON, because json.Unmarshal checks for validity early and
 // here we want to control the error that is returned.
-func (j *jmessages) parseJSON(data []byte) error {
+//
+// The ext keys name non-standard fields that are permitted in the messages,
+// and are recorded in the X field of each message (see jmessage.parseJSON).
+func (j *jmessages) parseJSON(data []byte, ext ...string) error {
 	*j = (*j)[:0] // reset state
 
 	// When parsing requests, validation checks are deferred: The only immediate
@@ -107,7 +112,7 @@ func (j *jmessages) parseJSON(data []byte) error {
 	// know that the messages are intact, but validity is checked at usage.
 	for _, raw := range msgs {
 		req := new(jmessage)
-		req.parseJSON(raw)
+		req.parseJSON(raw, ext...)
 		req.batch = batch
 		*j = append(*j, req)
 	}
@@ -127,6 +132,10 @@ type jmessage struct {
 	E *Error          // set on error
 	R json.RawMessage // set on success
 
+	// Non-standard extension fields, by key. These are only accepted from the
+	// wire for keys the receiver has enabled.
+	X map[string]json.RawMessage
+
 	// N.B.: In a valid protocol message, M and P are mutually exclusive with E
 	// and R. Specifically, if M != "" then E and R must both be unset. This is
 	// checked during parsing.
@@ -191,11 +200,28 @@ func (j *jmessage) toJSON() ([]byte, error) {
 		sb.Write(e)
 	}
 
+	for _, key := range slices.Sorted(maps.Keys(j.X)) {
+		if slices.Contains(standardKeys, key) {
+			continue // N.B. do not duplicate or override standard fields
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		sb.WriteByte(',')
+		sb.Write(k)
+		sb.WriteByte(':')
+		sb.Write(j.X[key])
+	}
+
 	sb.WriteByte('}')
 	return sb.Bytes(), nil
 }
 
-func (j *jmessage) parseJSON(data []byte) error {
+// parseJSON decodes a single message from data into j. Fields whose keys are
+// listed in ext are recorded in j.X; other non-standard fields make the message
+// invalid.
+func (j *jmessage) parseJSON(data []byte, ext ...string) error {
 	// Unmarshal into a map so we can check for extra keys.  The json.Decoder
 	// has DisallowUnknownFields, but fails decoding eagerly for fields that do
 	// not map to known tags. We want to fully parse the object so we can
@@ -242,7 +268,13 @@ func (j *jmessage) parseJSON(data []byte) error {
 		case "result":
 			j.R = val
 		default:
-			extra = append(extra, key)
+			if !slices.Contains(ext, key) {
+				extra = append(extra, key)
+			} else if j.X == nil {
+				j.X = map[string]json.RawMessage{key: val}
+			} else {
+				j.X[key] = val
+			}
 		}
 	}
 
@@ -0,0 +1,178 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jrpc2
+
+import (
+	"encoding/json"
+	"expvar"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Names of the counters maintained by each [Metrics] value, and rolled up
+// into the shared map returned by [ServerMetrics].
+const (
+	metricServersActive  = "servers_active"
+	metricRequests       = "rpc_requests"
+	metricErrors         = "rpc_errors"
+	metricBytesRead      = "bytes_read"
+	metricBytesWritten   = "bytes_written"
+	metricCallsPushed    = "calls_pushed"
+	metricNotesPushed    = "notifications_pushed"
+	metricHandlerPanics  = "handler_panics"
+	metricRequestsQueued = "requests_queued"
+	metricRequestsDenied = "requests_rejected"
+	metricRateLimited    = "requests_rate_limited"
+	metricPublishDropped = "publish_dropped"
+	metricUnauthorized   = "requests_unauthorized"
+	metricCacheHits      = "cache_hits"
+	metricCacheMisses    = "cache_misses"
+	metricRequestsSlow   = "requests_slow"
+	metricTimedOut       = "requests_timed_out"
+	metricReplayed       = "requests_replayed"
+)
+
+var metricNames = []string{
+	metricServersActive, metricRequests, metricErrors, metricBytesRead,
+	metricBytesWritten, metricCallsPushed, metricNotesPushed,
+	metricHandlerPanics, metricRequestsQueued, metricRequestsDenied,
+	metricRateLimited, metricPublishDropped, metricUnauthorized,
+	metricCacheHits, metricCacheMisses, metricRequestsSlow, metricTimedOut,
+	metricReplayed,
+}
+
+// latencyBounds are the upper bounds, in seconds, of the buckets of the
+// latency histograms recorded by a [Metrics] value.
+var latencyBounds = []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10}
+
+// Metrics records metrics for the servers that share it.  Each [Server] has a
+// Metrics value, either supplied by the Metrics field of its [ServerOptions]
+// or created for it by [NewServer].  Servers that share a Metrics value
+// report combined metrics.
+//
+// In addition to the counters maintained by the shared [ServerMetrics] map,
+// a Metrics value records per-method request counts, error codes, and
+// latencies.  Updates to the counters of a Metrics value are also added to the
+// shared map, so the shared map rolls up the totals for all servers.
+//
+// Metrics implements the [expvar.Var] interface, so it can be published
+// directly via [expvar.Publish].
+type Metrics struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	methods  map[string]*MethodMetrics
+}
+
+// NewMetrics constructs a new empty Metrics value.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		counters: make(map[string]int64),
+		methods:  make(map[string]*MethodMetrics),
+	}
+	for _, name := range metricNames {
+		m.counters[name] = 0
+	}
+	return m
+}
+
+// MetricsSnapshot is a point-in-time copy of the contents of a [Metrics].
+type MetricsSnapshot struct {
+	// Counters and gauges, with the same names as in [ServerMetrics].
+	Counters map[string]int64 `json:"counters"`
+
+	// Metrics for each method that has been dispatched to a handler, keyed
+	// by method name.
+	Methods map[string]*MethodMetrics `json:"methods,omitempty"`
+}
+
+// MethodMetrics records metrics for a single method.
+type MethodMetrics struct {
+	Requests int64          `json:"requests"`        // number of completed requests
+	Errors   int64          `json:"errors"`          // number of requests reporting errors
+	Codes    map[Code]int64 `json:"codes,omitempty"` // error counts by code
+	Latency  Histogram      `json:"latency"`         // handler latency, in seconds
+}
+
+// Histogram is a cumulative histogram of observed values.  Counts[i] is the
+// number of values v ≤ Bounds[i], and Counts[len(Bounds)] is the number of
+// all values, matching Count.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []int64   `json:"counts"`
+	Count  int64     `json:"count"`
+	Sum    float64   `json:"sum"`
+}
+
+func (h *Histogram) observe(v float64) {
+	if h.Counts == nil {
+		h.Bounds = latencyBounds
+		h.Counts = make([]int64, len(h.Bounds)+1)
+	}
+	for i, b := range h.Bounds {
+		if v <= b {
+			h.Counts[i]++
+		}
+	}
+	h.Counts[len(h.Bounds)]++
+	h.Count++
+	h.Sum += v
+}
+
+// Snapshot returns a copy of the current contents of m.
+func (m *Metrics) Snapshot() *MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snap := &MetricsSnapshot{
+		Counters: maps.Clone(m.counters),
+		Methods:  make(map[string]*MethodMetrics, len(m.methods)),
+	}
+	for name, mm := range m.methods {
+		cp := *mm
+		cp.Codes = maps.Clone(mm.Codes)
+		cp.Latency.Counts = slices.Clone(mm.Latency.Counts)
+		snap.Methods[name] = &cp
+	}
+	return snap
+}
+
+// String encodes a snapshot of m as JSON. It implements [expvar.Var].
+func (m *Metrics) String() string {
+	bits, err := json.Marshal(m.Snapshot())
+	if err != nil {
+		return "{}"
+	}
+	return string(bits)
+}
+
+// add adds n to the named counter in m, and to the shared map.
+func (m *Metrics) add(name string, n int64) {
+	m.mu.Lock()
+	m.counters[name] += n
+	m.mu.Unlock()
+	serverMetrics.Add(name, n)
+}
+
+// observe records the completion of a request for method with the given
+// error code and latency.
+func (m *Metrics) observe(method string, code Code, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mm := m.methods[method]
+	if mm == nil {
+		mm = new(MethodMetrics)
+		m.methods[method] = mm
+	}
+	mm.Requests++
+	if code != NoError {
+		mm.Errors++
+		if mm.Codes == nil {
+			mm.Codes = make(map[Code]int64)
+		}
+		mm.Codes[code]++
+	}
+	mm.Latency.observe(elapsed.Seconds())
+}
+
+var _ expvar.Var = (*Metrics)(nil)
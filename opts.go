@@ -8,12 +8,16 @@ import (
 	"fmt"
 	"log"
 	"runtime"
+	"slices"
+	"sync"
 	"time"
 )
 
 // ServerOptions control the behaviour of a server created by [NewServer].  A
 // nil *ServerOptions is valid and provides sensible defaults.  It is safe to
-// share server options among multiple server instances.
+// share server options among multiple server instances.  Servers that share
+// options, such as all the servers started by a server.Loop, also share the
+// state held by the values the options refer to, such as a Metrics value.
 type ServerOptions struct {
 	// If not nil, send debug text logs here.
 	Logger Logger
@@ -47,8 +51,205 @@ type ServerOptions struct {
 	// current time when Start is called. All servers created from the same
 	// options will share the same start time if one is set.
 	StartTime time.Time
+
+	// If set, each handler invoked by the server is wrapped by these
+	// interceptors, in order, so that Interceptors[0] is the outermost.  This
+	// applies to the built-in rpc.* methods as well as to the handlers
+	// returned by the assigner.
+	Interceptors []Interceptor
+
+	// If true, the server recovers a panic from a handler (or interceptor) and
+	// reports it to the client as an InternalError, rather than allowing the
+	// panic to terminate the process. Recovered panics are logged, and counted
+	// in the server metrics.
+	RecoverPanics bool
+
+	// If true, and RecoverPanics is also true, the stack trace of a recovered
+	// panic is included in the Data field of the error reported to the client.
+	// Note that this may expose implementation details to the caller.
+	PanicStack bool
+
+	// If positive, the maximum number of requests the server will accept from
+	// the client that have not yet completed, whether they are waiting in the
+	// queue or running in a handler.  When a batch arrives that would exceed
+	// this limit, each call in the batch is rejected immediately with an
+	// Overloaded error, and notifications are handled as directed by the
+	// OverloadPolicy. A batch is always accepted if no other requests are
+	// pending. If zero or negative, there is no limit.
+	MaxQueuedRequests int
+
+	// Determines how notifications are handled when they arrive while the
+	// server has more than MaxQueuedRequests pending.
+	OverloadPolicy OverloadPolicy
+
+	// If not nil, record metrics for the server here. Servers that share a
+	// Metrics value report combined metrics. If nil, each server records
+	// metrics in its own Metrics value.
+	Metrics *Metrics
+
+	// If not nil, the server accepts trace context from the client, reports
+	// spans for the requests it executes, and attaches trace context to its
+	// callbacks and notifications, using this tracer.  If nil, requests that
+	// include trace context are rejected as invalid.
+	Tracer Tracer
+
+	// If enabled, limit the rate at which the server accepts requests from the
+	// client.  Requests that exceed the limit are handled as directed by the
+	// RateLimitPolicy.
+	RateLimit RateLimit
+
+	// Rate limits for specific methods, by name. A request for a method listed
+	// here is subject to this limit instead of RateLimit.  Each method has its
+	// own separate limit.
+	MethodRateLimits map[string]RateLimit
+
+	// Determines how requests that exceed the rate limits are handled.
+	RateLimitPolicy RateLimitPolicy
+
+	// If positive, the maximum size in bytes of a message the server will
+	// accept from the client.  The server replies to a larger message with an
+	// InvalidRequest error, and continues reading.  The server also treats a
+	// *channel.RecordTooLargeError from its channel in the same way.
+	//
+	// Note that this limit applies after the channel has received the message.
+	// To avoid buffering large messages at all, use a channel framing with a
+	// size limit (see channel.MaxSize).
+	MaxMessageSize int
+
+	// If set, this function is called to compute an ordering key for each
+	// request the server receives.  Requests that share a non-empty key are
+	// executed one at a time, in the order they were received, even across
+	// batches.  Requests with different keys, or with an empty key, run
+	// concurrently subject to the Concurrency setting.  A request waiting for
+	// its predecessor does not count against the Concurrency limit.
+	//
+	// The function is called while the server holds a lock, and must not call
+	// back into the server.
+	OrderKey func(*Request) string
+
+	// If not nil, enable the built-in rpc.subscribe and rpc.unsubscribe
+	// methods, allowing the client to subscribe to messages published to this
+	// hub.  Servers that share a Hub deliver its messages to all their clients.
+	// Delivery uses server notifications, so AllowPush must also be true.
+	Hub *Hub
+
+	// If set, this function is called to authorize each request after its
+	// handler has been resolved, and before the handler is invoked.  If it
+	// reports an error, the handler is not run, and the client receives an
+	// Unauthorized error with the message (and data, if the error is an
+	// *Error) of the reported error.
+	//
+	// The context passed to Authorize is the handler context, which includes
+	// the request, the server, and its session (see SessionFromContext).  The
+	// credentials sent by the client, if any, are available from the
+	// Credentials method of the request.  A server with an Authorize function
+	// accepts requests that include credentials; otherwise such requests are
+	// rejected as invalid.  The built-in rpc.cancel method is not subject to
+	// authorization, as it can only cancel requests on the same connection.
+	Authorize func(context.Context, *Request) error
+
+	// The keys of non-standard extension fields the server accepts in
+	// messages from the client, in addition to those enabled by other options
+	// (such as Tracer).  If empty, the server rejects messages with unknown
+	// fields as invalid.  Use Request.Extension and Response.Extension to
+	// read the values of these fields.  NewServer panics if this includes
+	// the name of a standard or built-in field (see WithExtension).
+	Extensions []string
+
+	// If true, the server accepts a time budget from the client with each
+	// call, and the context passed to the handler ends when the budget
+	// expires.  The server also sends the time remaining before the deadline
+	// of the context passed to Callback (if any) with each callback.  The time
+	// budget is encoded as a non-standard "timeout" field in the request,
+	// giving the remaining time in milliseconds.
+	PropagateTimeouts bool
+
+	// If positive, the maximum time budget the server accepts from the client.
+	// A longer budget is reduced to this limit.  This setting has no effect
+	// unless PropagateTimeouts is true.
+	MaxTimeout time.Duration
+
+	// If not nil, results of calls to the methods enabled in the cache are
+	// served from and recorded in this cache.  The cache is consulted after
+	// the request has been authorized, and inside any Interceptors.
+	Cache *Cache
+
+	// If positive, the server logs a warning when the handler for a request
+	// runs longer than this, including the method, ID, and parameters of the
+	// request and the current stack trace of the handler goroutine.  Such
+	// requests are counted in the server metrics.
+	SlowRequestWarning time.Duration
+
+	// If positive, the server cancels the context of a handler that runs
+	// longer than this, and replies to the call with a DeadlineExceeded error
+	// when the handler returns.  Such requests are counted in the server
+	// metrics.  Note that a handler that does not respect cancellation of its
+	// context will still delay the reply until it returns.
+	SlowRequestLimit time.Duration
+
+	// If not nil, enable idempotency keys for calls, using this store to
+	// record the results of completed calls.  When the server receives a call
+	// with an idempotency key for which the store has a result, it replies
+	// with that result without invoking the handler.  Results are recorded
+	// after the request has been authorized, and inside any Interceptors.
+	// Errors due to cancellation or a deadline are not recorded, so that the
+	// client may retry.  A call that arrives while another call with the same
+	// key is still in progress waits for that call to complete, and replays
+	// its result if it was recorded.
+	//
+	// Keys are scoped by method name and by the credentials of the request
+	// (see WithCredentials), so that callers with different credentials do
+	// not see each other's results.  If callers are identified some other way,
+	// the IdempotencyKey function must include the identity of the caller in
+	// the keys it returns.
+	//
+	// Servers that share a store share their results.  Use NewMemoryStore
+	// for a store that keeps results in memory.
+	//
+	// By default, the client sends the key for a call as a non-standard
+	// "idempotencyKey" field in the request (see WithIdempotencyKey).
+	IdempotencyStore IdempotencyStore
+
+	// If set, and IdempotencyStore is not nil, this function is called to
+	// obtain the idempotency key for each call, for example from its
+	// parameters, instead of the "idempotencyKey" field.  An empty key means
+	// the call does not have a key.  The key must identify the caller, unless
+	// callers are distinguished by their credentials (see IdempotencyStore).
+	IdempotencyKey func(*Request) string
+
+	// If true, and AllowPush is also true, the server sends an
+	// "rpc.methodsChanged" notification to the client when its assigner is
+	// replaced by the SetAssigner method.
+	NotifyMethodsChanged bool
+
+	// The title and version of the API, reported in the info section of the
+	// document returned by the rpc.discover method (see Server.Discover).  If
+	// nil, the title is "jrpc2" and the version is "0.0.0".
+	DiscoveryInfo *DiscoveryInfo
+
+	// Calls in progress with idempotency keys, shared by the servers that use
+	// these options.  This is created on first use (see keyedCalls).
+	idemCalls *keyedCalls
 }
 
+// idemCallsMu protects the creation of ServerOptions.idemCalls.
+var idemCallsMu sync.Mutex
+
+// An OverloadPolicy determines how a server handles notifications received
+// when it has too many pending requests (see ServerOptions.MaxQueuedRequests).
+type OverloadPolicy int
+
+const (
+	// DropNotifications discards notifications received while the server is
+	// overloaded. This is the default policy.
+	DropNotifications OverloadPolicy = iota
+
+	// BlockNotifications causes the server to stop reading from the client
+	// until there is room for the notifications to be queued.  While the
+	// server is blocked, it does not receive replies to server callbacks.
+	BlockNotifications
+)
+
 func (s *ServerOptions) logFunc() func(string, ...any) {
 	if s == nil || s.Logger == nil {
 		return func(string, ...any) {}
@@ -56,8 +257,10 @@ func (s *ServerOptions) logFunc() func(string, ...any) {
 	return s.Logger.Printf
 }
 
-func (s *ServerOptions) allowPush() bool    { return s != nil && s.AllowPush }
-func (s *ServerOptions) allowBuiltin() bool { return s == nil || !s.DisableBuiltin }
+func (s *ServerOptions) allowPush() bool     { return s != nil && s.AllowPush }
+func (s *ServerOptions) allowBuiltin() bool  { return s == nil || !s.DisableBuiltin }
+func (s *ServerOptions) recoverPanics() bool { return s != nil && s.RecoverPanics }
+func (s *ServerOptions) panicStack() bool    { return s != nil && s.PanicStack }
 
 func (s *ServerOptions) concurrency() int64 {
 	if s == nil || s.Concurrency < 1 {
@@ -66,6 +269,115 @@ func (s *ServerOptions) concurrency() int64 {
 	return int64(s.Concurrency)
 }
 
+func (s *ServerOptions) maxQueued() int {
+	if s == nil || s.MaxQueuedRequests < 0 {
+		return 0
+	}
+	return s.MaxQueuedRequests
+}
+
+func (s *ServerOptions) overloadPolicy() OverloadPolicy {
+	if s == nil {
+		return DropNotifications
+	}
+	return s.OverloadPolicy
+}
+
+func (s *ServerOptions) metrics() *Metrics {
+	if s == nil || s.Metrics == nil {
+		return NewMetrics()
+	}
+	return s.Metrics
+}
+
+func (s *ServerOptions) tracer() Tracer {
+	if s == nil {
+		return nil
+	}
+	return s.Tracer
+}
+
+func (s *ServerOptions) limiter() *limiter {
+	if s == nil {
+		return nil
+	}
+	return newLimiter(s.RateLimit, s.MethodRateLimits, s.RateLimitPolicy)
+}
+
+func (s *ServerOptions) maxMessageSize() int {
+	if s == nil || s.MaxMessageSize < 0 {
+		return 0
+	}
+	return s.MaxMessageSize
+}
+
+func (s *ServerOptions) orderKey() func(*Request) string {
+	if s == nil {
+		return nil
+	}
+	return s.OrderKey
+}
+
+func (s *ServerOptions) hub() *Hub {
+	if s == nil {
+		return nil
+	}
+	return s.Hub
+}
+
+func (s *ServerOptions) authorize() func(context.Context, *Request) error {
+	if s == nil {
+		return nil
+	}
+	return s.Authorize
+}
+
+func (s *ServerOptions) extensions() []string {
+	if s == nil {
+		return nil
+	}
+	return s.Extensions
+}
+
+// extensionKeys returns the keys of all the extension fields the server
+// accepts from the client, including those enabled by other options.
+func (s *ServerOptions) extensionKeys() []string {
+	return slices.Concat(traceKeys(s.tracer()), authKeys(s.authorize()),
+		timeoutKeys(s.propagateTimeouts()),
+		idempotencyKeys(s.idempotencyStore(), s.idempotencyKey()), s.extensions())
+}
+
+func (s *ServerOptions) propagateTimeouts() bool    { return s != nil && s.PropagateTimeouts }
+func (s *ServerOptions) notifyMethodsChanged() bool { return s != nil && s.NotifyMethodsChanged }
+
+func (s *ServerOptions) discoveryInfo() DiscoveryInfo {
+	if s == nil || s.DiscoveryInfo == nil {
+		return DiscoveryInfo{Title: "jrpc2", Version: "0.0.0"}
+	}
+	return *s.DiscoveryInfo
+}
+
+func (s *ServerOptions) maxTimeout() time.Duration {
+	if s == nil || s.MaxTimeout < 0 {
+		return 0
+	}
+	return s.MaxTimeout
+}
+
+func (s *ServerOptions) slowRequestWarning() time.Duration {
+	if s == nil || s.SlowRequestWarning < 0 {
+		return 0
+	}
+	return s.SlowRequestWarning
+}
+
+func (s *ServerOptions) slowRequestLimit() time.Duration {
+	if s == nil || s.SlowRequestLimit < 0 {
+		return 0
+	}
+	return s.SlowRequestLimit
+}
+
 func (s *ServerOptions) startTime() time.Time {
 	if s == nil {
 		return time.Time{}
@@ -80,6 +392,46 @@ func (s *ServerOptions) newContext() func() context.Context {
 	return s.NewContext
 }
 
+func (s *ServerOptions) interceptors() []Interceptor {
+	if s == nil {
+		return nil
+	}
+	ics := slices.Clone(s.Interceptors)
+	if s.IdempotencyStore != nil {
+		id := idempotency{store: s.IdempotencyStore, keyOf: s.IdempotencyKey, calls: s.keyedCalls()}
+		ics = append(ics, id.intercept)
+	}
+	if s.Cache != nil {
+		ics = append(ics, s.Cache.intercept)
+	}
+	return ics
+}
+
+func (s *ServerOptions) idempotencyStore() IdempotencyStore {
+	if s == nil {
+		return nil
+	}
+	return s.IdempotencyStore
+}
+
+func (s *ServerOptions) idempotencyKey() func(*Request) string {
+	if s == nil {
+		return nil
+	}
+	return s.IdempotencyKey
+}
+
+// keyedCalls returns the tracker for calls in progress with idempotency keys
+// for s, creating it if necessary.
+func (s *ServerOptions) keyedCalls() *keyedCalls {
+	idemCallsMu.Lock()
+	defer idemCallsMu.Unlock()
+	if s.idemCalls == nil {
+		s.idemCalls = &keyedCalls{busy: make(map[string]chan struct{})}
+	}
+	return s.idemCalls
+}
+
 func (s *ServerOptions) rpcLog() RPCLogger {
 	if s == nil || s.RPCLog == nil {
 		return nullRPCLogger{}
@@ -121,10 +473,36 @@ type ClientOptions struct {
 	// already ended by the time the hook is called.
 	OnCancel func(cli *Client, rsp *Response)
 
+	// If true, the client sends an "rpc.cancel" notification to the server
+	// when the context for a request terminates before the server has
+	// responded.  A server with built-in methods enabled handles this by
+	// cancelling the context of the corresponding handler.
+	PropagateCancel bool
+
 	// If set, this function is called when the client is stopped, either by
 	// calling its Close method or by disconnection of its channel.  The
 	// arguments are the client itself and the error that caused it to stop.
 	OnStop func(cli *Client, err error)
+
+	// If not nil, the client attaches trace context to its requests, and
+	// reports spans for callbacks from the server, using this tracer.  The
+	// server must also have a Tracer to accept trace context.
+	Tracer Tracer
+
+	// The keys of non-standard extension fields the client accepts in
+	// messages from the server, in addition to those enabled by other options
+	// (such as Tracer).  If empty, the client rejects messages with unknown
+	// fields as invalid.  NewClient panics if this includes the name of a
+	// standard or built-in field (see WithExtension).
+	Extensions []string
+
+	// If true, the client sends the time remaining before the deadline of the
+	// context for each call (if any), so that the server can stop working on
+	// the call when the client gives up on it.  The client also accepts a time
+	// budget with callbacks from the server, and the context passed to the
+	// OnCallback handler ends when it expires.  The server must also enable
+	// PropagateTimeouts to accept time budgets.
+	PropagateTimeouts bool
 }
 
 func (c *ClientOptions) logFunc() func(string, ...any) {
@@ -139,7 +517,7 @@ func (c *ClientOptions) handleNotification() func(*jmessage) {
 		return nil
 	}
 	h := c.OnNotify
-	return func(req *jmessage) { h(&Request{method: req.M, params: req.P}) }
+	return func(req *jmessage) { h(&Request{method: req.M, params: req.P, ext: req.X}) }
 }
 
 func (c *ClientOptions) handleCancel() func(*Client, *Response) {
@@ -149,6 +527,29 @@ func (c *ClientOptions) handleCancel() func(*Client, *Response) {
 	return c.OnCancel
 }
 
+func (c *ClientOptions) propagateCancel() bool   { return c != nil && c.PropagateCancel }
+func (c *ClientOptions) propagateTimeouts() bool { return c != nil && c.PropagateTimeouts }
+
+func (c *ClientOptions) tracer() Tracer {
+	if c == nil {
+		return nil
+	}
+	return c.Tracer
+}
+
+func (c *ClientOptions) extensions() []string {
+	if c == nil {
+		return nil
+	}
+	return c.Extensions
+}
+
+// extensionKeys returns the keys of all the extension fields the client
+// accepts from the server, including those enabled by other options.
+func (c *ClientOptions) extensionKeys() []string {
+	return slices.Concat(traceKeys(c.tracer()), timeoutKeys(c.propagateTimeouts()), c.extensions())
+}
+
 func (c *ClientOptions) handleStop() func(*Client, error) {
 	if c == nil || c.OnStop == nil {
 		return func(*Client, error) {}
@@ -160,7 +561,7 @@ func (c *ClientOptions) handleCallback() func(context.Context, *jmessage) []byte
 	if c == nil || c.OnCallback == nil {
 		return nil
 	}
-	cb := c.OnCallback
+	cb, tracer, ptime := c.OnCallback, c.Tracer, c.PropagateTimeouts
 	return func(ctx context.Context, req *jmessage) []byte {
 		// Recover panics from the callback handler to ensure the server gets a
 		// response even if the callback fails without a result.
@@ -172,13 +573,22 @@ func (c *ClientOptions) handleCallback() func(context.Context, *jmessage) []byte
 		//
 		// See https://github.com/creachadair/jrpc2/issues/41.
 		rsp := &jmessage{ID: req.ID}
+		hreq := &Request{
+			id:     req.ID,
+			method: req.M,
+			params: req.P,
+			ext:    req.X,
+		}
+		if d := timeoutOf(hreq, 0); ptime && d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+		ctx, end := startTrace(tracer, ctx, hreq)
 		v, err := panicToError(func() (any, error) {
-			return cb(ctx, &Request{
-				id:     req.ID,
-				method: req.M,
-				params: req.P,
-			})
+			return cb(ctx, hreq)
 		})
+		end(err)
 		if err == nil {
 			rsp.R, err = json.Marshal(v)
 		}
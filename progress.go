@@ -0,0 +1,76 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jrpc2
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// rpcProgress is the method name of the server notifications that carry
+// progress reports for pending calls (see ReportProgress).
+const rpcProgress = "rpc.progress"
+
+// progressParams are the parameters of an rpc.progress notification.
+type progressParams struct {
+	ID    json.RawMessage `json:"id"`    // the ID of the call in progress
+	Value any             `json:"value"` // the progress value reported
+}
+
+// ReportProgress sends value to the client as a progress report for the
+// inbound call whose handler has context ctx.  The value must be
+// JSON-marshalable.  The report is sent as an "rpc.progress" notification,
+// whose parameters are an object giving the ID of the call and the value:
+//
+//	{"jsonrpc":"2.0", "method":"rpc.progress", "params":{"id":1, "value":...}}
+//
+// Progress reports are sent in order, and before the response to the call.
+// A [Client] delivers them to the OnProgress callback of the [CallOptions] for
+// the call, if one is set, and otherwise discards them.
+//
+// If ctx is not the context of a call handler, or if the server does not
+// allow push notifications, ReportProgress does nothing and returns nil.
+func ReportProgress(ctx context.Context, value any) error {
+	srv, req := serverKey.Lookup(ctx).Get(), InboundRequest(ctx)
+	if srv == nil || req == nil || req.IsNotification() || !srv.allowP {
+		return nil
+	}
+	return srv.Notify(ctx, rpcProgress, progressParams{ID: req.id, Value: value})
+}
+
+// CallOptions are optional settings for a single call issued by
+// [Client.CallWith].  A nil *CallOptions is valid and provides default values.
+type CallOptions struct {
+	// If set, this function is called with the value of each progress report
+	// sent by the server for the call (see ReportProgress).  All progress
+	// reports are delivered before the call returns.  At most one invocation
+	// of the callback will be active at a time, and it must not block or call
+	// methods of the client.
+	OnProgress func(value json.RawMessage)
+}
+
+func (o *CallOptions) onProgress() func(json.RawMessage) {
+	if o == nil {
+		return nil
+	}
+	return o.OnProgress
+}
+
+// handleProgressLocked delivers a progress report to the pending call it
+// refers to, if any.  The caller must hold c.mu.
+func (c *Client) handleProgressLocked(msg *jmessage) {
+	var p struct {
+		ID    json.RawMessage `json:"id"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(msg.P, &p); err != nil {
+		c.log("Discarding invalid progress report: %v", err)
+		return
+	}
+	id := string(fixID(p.ID))
+	if rsp := c.pending[id]; rsp != nil && rsp.progress != nil {
+		rsp.progress(p.Value)
+	} else {
+		c.log("Discarding progress report for ID %q", id)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jrpc2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// defaultHubBuffer is the number of messages queued for each subscriber of a
+// Hub, if the caller does not specify a size.
+const defaultHubBuffer = 64
+
+// A Hub delivers published messages to the clients of the servers that share
+// it.  To enable publish/subscribe, set the Hub field of the [ServerOptions].
+// Each message published to a Hub is delivered to every client of those
+// servers that is subscribed to its topic.
+//
+// A client subscribes to topics by calling the built-in "rpc.subscribe"
+// method, and unsubscribes by calling "rpc.unsubscribe".  Each takes an array
+// of topic names as its parameters.  A message published to a topic is sent
+// to each subscribed client as a server notification whose method name is the
+// topic, so the server must also have the AllowPush option enabled.
+//
+// Each subscribed connection has its own queue of pending messages.  If a
+// client does not keep up and its queue is full, further messages for that
+// client are discarded (and counted in the server metrics) until there is
+// room.  Subscriptions are removed when the server stops, or when delivery
+// fails because the client connection has closed.
+type Hub struct {
+	buffer int
+
+	mu   sync.Mutex
+	subs map[*Server]*subscriber
+}
+
+// NewHub constructs a new empty Hub that queues up to buffer messages for
+// each subscribed connection.  If buffer ≤ 0, a default size is used.
+func NewHub(buffer int) *Hub {
+	if buffer <= 0 {
+		buffer = defaultHubBuffer
+	}
+	return &Hub{buffer: buffer, subs: make(map[*Server]*subscriber)}
+}
+
+// A subscriber records the topics subscribed by the client of a server, and
+// the queue of messages waiting to be sent to it.
+type subscriber struct {
+	srv    *Server
+	topics map[string]bool
+	queue  chan publication
+}
+
+// A publication is a single published message.
+type publication struct {
+	topic  string
+	params any // encoded JSON, or nil
+}
+
+// Publish sends payload to each client subscribed to topic, and reports the
+// number of clients for which the message was queued. The payload must encode
+// to a JSON array or object, or be nil.  Publish does not wait for the
+// messages to be delivered.
+func (h *Hub) Publish(topic string, payload any) (int, error) {
+	pub := publication{topic: topic}
+	if payload != nil {
+		bits, err := json.Marshal(payload)
+		if err != nil {
+			return 0, err
+		}
+		pub.params = json.RawMessage(bits)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var n int
+	for _, sub := range h.subs {
+		if !sub.topics[topic] {
+			continue
+		}
+		select {
+		case sub.queue <- pub:
+			n++
+		default:
+			sub.srv.log("Discarding message for topic %q: subscriber queue is full", topic)
+			sub.srv.metrics.add(metricPublishDropped, 1)
+		}
+	}
+	return n, nil
+}
+
+// Topics reports the number of subscribers to each topic that has at least
+// one subscriber.
+func (h *Hub) Topics() map[string]int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make(map[string]int)
+	for _, sub := range h.subs {
+		for topic := range sub.topics {
+			out[topic]++
+		}
+	}
+	return out
+}
+
+// subscribe adds topics to the subscriptions of the client of s.
+func (h *Hub) subscribe(s *Server, topics []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub := h.subs[s]
+	if sub == nil {
+		sub = &subscriber{
+			srv:    s,
+			topics: make(map[string]bool),
+			queue:  make(chan publication, h.buffer),
+		}
+		h.subs[s] = sub
+		go sub.run(h)
+	}
+	for _, topic := range topics {
+		sub.topics[topic] = true
+	}
+}
+
+// unsubscribe removes topics from the subscriptions of the client of s.
+func (h *Hub) unsubscribe(s *Server, topics []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub := h.subs[s]
+	if sub == nil {
+		return
+	}
+	for _, topic := range topics {
+		delete(sub.topics, topic)
+	}
+	if len(sub.topics) == 0 {
+		h.removeLocked(sub)
+	}
+}
+
+// remove discards all the subscriptions of the client of s.
+func (h *Hub) remove(s *Server) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if sub := h.subs[s]; sub != nil {
+		h.removeLocked(sub)
+	}
+}
+
+// removeLocked discards sub, if it is still current. The caller must hold h.mu.
+func (h *Hub) removeLocked(sub *subscriber) {
+	if h.subs[sub.srv] == sub {
+		delete(h.subs, sub.srv)
+		close(sub.queue)
+	}
+}
+
+// run delivers queued messages to the client of sub until its queue closes.
+func (sub *subscriber) run(h *Hub) {
+	for pub := range sub.queue {
+		err := sub.srv.Notify(context.Background(), pub.topic, pub.params)
+		if errors.Is(err, ErrConnClosed) {
+			h.mu.Lock()
+			h.removeLocked(sub)
+			h.mu.Unlock()
+		} else if err != nil {
+			sub.srv.log("Delivering message for topic %q failed: %v", pub.topic, err)
+		}
+	}
+}
+
+// handleRPCSubscribe implements the rpc.subscribe and rpc.unsubscribe built-in
+// methods.  Their parameters are an array of topic names.
+func (s *Server) handleRPCSubscribe(_ context.Context, req *Request) (any, error) {
+	if !s.allowP {
+		return nil, errPushDisabled
+	}
+	var topics []string
+	if err := req.UnmarshalParams(&topics); err != nil {
+		return nil, err
+	} else if slices.Contains(topics, "") {
+		return nil, Errorf(InvalidParams, "empty topic name")
+	}
+	if req.Method() == rpcSubscribe {
+		s.hub.subscribe(s, topics)
+	} else {
+		s.hub.unsubscribe(s, topics)
+	}
+	return nil, nil
+}
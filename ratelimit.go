@@ -0,0 +1,117 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jrpc2
+
+import (
+	"math"
+	"time"
+)
+
+// RateLimit describes a token-bucket limit on the rate of requests.  The
+// bucket holds up to Burst tokens, and refills at Rate tokens per second.
+// Each request consumes one token.
+type RateLimit struct {
+	Rate  float64 // sustained requests per second; zero or negative means no limit
+	Burst int     // maximum burst size; values less than 1 are treated as 1
+}
+
+func (r RateLimit) enabled() bool { return r.Rate > 0 }
+
+// A RateLimitPolicy determines how a server handles requests that exceed its
+// rate limits (see ServerOptions.RateLimit).
+type RateLimitPolicy int
+
+const (
+	// RejectExcess causes calls that exceed the rate limit to be rejected with
+	// a RateLimited error, and notifications to be discarded. The Data field of
+	// the error is a [RetryAfter] value.  This is the default policy.
+	RejectExcess RateLimitPolicy = iota
+
+	// DelayExcess causes the server to stop reading from the client until the
+	// requests are within the rate limit.  While the server is delayed, it does
+	// not receive replies to server callbacks.
+	DelayExcess
+)
+
+// RetryAfter is the data attached to a RateLimited error, reporting how long
+// the client should wait before retrying the request.
+type RetryAfter struct {
+	Seconds float64 `json:"retryAfter"`
+}
+
+// errRateLimited is the error reported for a call that exceeds the rate limit
+// of the server. Its data are filled in by the limiter.
+var errRateLimited = &Error{Code: RateLimited, Message: "request rate limit exceeded"}
+
+// A bucket is a token bucket. It is not safe for concurrent use.
+type bucket struct {
+	rate   float64   // tokens per second
+	burst  float64   // maximum tokens
+	tokens float64   // tokens currently available (negative if reserved)
+	last   time.Time // when tokens was last updated
+}
+
+func newBucket(r RateLimit) *bucket {
+	burst := float64(max(r.Burst, 1))
+	return &bucket{rate: r.Rate, burst: burst, tokens: burst}
+}
+
+// take removes a token from b at time now, and returns how long the caller
+// must wait before the token is available.  If no token is available and
+// reserve is false, no token is removed; otherwise the token is reserved
+// against future refills.
+func (b *bucket) take(now time.Time, reserve bool) time.Duration {
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	wait := time.Duration(math.Ceil((1 - b.tokens) / b.rate * float64(time.Second)))
+	if reserve {
+		b.tokens--
+	}
+	return wait
+}
+
+// A limiter applies rate limits to the requests received by a server.
+type limiter struct {
+	server  *bucket            // the server-wide bucket, or nil
+	methods map[string]*bucket // per-method overrides
+	delay   bool               // delay rather than reject excess requests
+}
+
+// newLimiter constructs a limiter for the given settings, or returns nil if
+// no limits are enabled.
+func newLimiter(server RateLimit, methods map[string]RateLimit, policy RateLimitPolicy) *limiter {
+	lim := &limiter{delay: policy == DelayExcess}
+	if server.enabled() {
+		lim.server = newBucket(server)
+	}
+	for name, r := range methods {
+		if r.enabled() {
+			if lim.methods == nil {
+				lim.methods = make(map[string]*bucket)
+			}
+			lim.methods[name] = newBucket(r)
+		}
+	}
+	if lim.server == nil && lim.methods == nil {
+		return nil
+	}
+	return lim
+}
+
+// take charges a request for method against the applicable bucket at time
+// now, and reports how long the request must wait. A method with its own limit
+// is charged only against that limit.
+func (lim *limiter) take(method string, now time.Time) time.Duration {
+	if b, ok := lim.methods[method]; ok {
+		return b.take(now, lim.delay)
+	} else if lim.server != nil {
+		return lim.server.take(now, lim.delay)
+	}
+	return 0
+}
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"expvar"
 	"io"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
@@ -20,34 +21,28 @@ import (
 
 const (
 	rpcServerInfo = "rpc.serverInfo"
-)
+	rpcCancel     = "rpc.cancel"
+	rpcDiscover   = "rpc.discover"
 
-var (
-	serverMetrics = new(expvar.Map)
+	rpcSubscribe   = "rpc.subscribe"
+	rpcUnsubscribe = "rpc.unsubscribe"
 
-	serversActiveGauge     = new(expvar.Int)
-	rpcRequestsCount       = new(expvar.Int)
-	rpcErrorsCount         = new(expvar.Int)
-	bytesReadCount         = new(expvar.Int)
-	bytesWrittenCount      = new(expvar.Int)
-	rpcCallsPushed         = new(expvar.Int)
-	rpcNotificationsPushed = new(expvar.Int)
+	rpcMethodsChanged = "rpc.methodsChanged"
 )
 
+var serverMetrics = new(expvar.Map)
+
 func init() {
-	serverMetrics.Set("servers_active", serversActiveGauge)
-	serverMetrics.Set("rpc_requests", rpcRequestsCount)
-	serverMetrics.Set("rpc_errors", rpcErrorsCount)
-	serverMetrics.Set("bytes_read", bytesReadCount)
-	serverMetrics.Set("bytes_written", bytesWrittenCount)
-	serverMetrics.Set("calls_pushed", rpcCallsPushed)
-	serverMetrics.Set("notifications_pushed", rpcNotificationsPushed)
+	for _, name := range metricNames {
+		serverMetrics.Set(name, new(expvar.Int))
+	}
 }
 
 // ServerMetrics returns a map of exported server metrics for use with the
 // expvar package. This map is shared among all server instances created by
-// NewServer. The caller is free to add or remove metrics in the map, but note
-// that such changes will affect all servers.
+// NewServer, and rolls up the counters from each server's [Metrics]. The
+// caller is free to add or remove metrics in the map, but note that such
+// changes will affect all servers.
 //
 // The caller is responsible for publishing the metrics to the exporter via
 // [expvar.Publish] or similar.
@@ -58,7 +53,6 @@ func ServerMetrics() *expvar.Map { return serverMetrics }
 // dispatches requests to user-defined Handlers.
 type Server struct {
 	wg  sync.WaitGroup      // ready when workers are done at shutdown time
-	mux Assigner            // associates method names with handlers
 	sem *semaphore.Weighted // bounds concurrent execution (default 1)
 
 	// Configurable settings
@@ -68,6 +62,28 @@ type Server struct {
 	newctx  func() context.Context // create a new base request context
 	start   time.Time              // when Start was called
 	builtin bool                   // whether built-in rpc.* methods are enabled
+	ics     []Interceptor          // wrap handler invocations
+	recover bool                   // recover panics from handlers
+	pstack  bool                   // include stacks in panic errors
+	maxq    int                    // maximum pending requests (0 means no limit)
+	blockN  bool                   // block rather than drop notifications on overload
+	metrics *Metrics               // record server metrics here
+	tracer  Tracer                 // propagate trace context and report spans
+	limit   *limiter               // request rate limits, or nil
+	maxMsg  int                    // maximum inbound message size (0 means no limit)
+	okey    func(*Request) string  // compute request ordering keys, or nil
+	hub     *Hub                   // publish/subscribe hub, or nil
+	xkeys   []string               // extension fields accepted from the client
+	ptime   bool                   // send time budgets with callbacks
+	maxTO   time.Duration          // maximum time budget accepted (0 means no limit)
+	notifyM bool                   // notify the client when the assigner changes
+	dinfo   DiscoveryInfo          // the info section of the discovery document
+
+	slowWarn  time.Duration // warn about handlers running longer than this
+	slowLimit time.Duration // cancel handlers running longer than this
+
+	// If set, authorize each request before invoking its handler.
+	authz func(context.Context, *Request) error
 
 	mu *sync.Mutex // protects the fields below
 
@@ -76,6 +92,18 @@ type Server struct {
 	work chan struct{}          // for signaling message availability
 	inq  queue.Queue[jmessages] // inbound requests awaiting processing
 	ch   channel.Channel        // the channel to the client
+	nrun int                    // number of batches dispatched but not delivered
+	qlen int                    // number of requests received but not completed
+	room chan struct{}          // for signaling space available in the queue
+	sess *Session               // per-connection session values
+	mux  Assigner               // associates method names with handlers
+
+	// For each ordering key with a request in flight, this map carries a
+	// channel that is closed when the most recent such request completes.
+	order map[string]chan struct{}
+
+	draining bool          // the server is draining for shutdown
+	drain    chan struct{} // closed when draining is complete (see Shutdown)
 
 	// For each request ID currently in-flight, this map carries a cancel
 	// function attached to the context that was sent to the handler.
@@ -90,13 +118,16 @@ type Server struct {
 // NewServer returns a new unstarted server that will dispatch incoming
 // JSON-RPC requests according to mux. To start serving, call Start.
 //
-// This function will panic if mux == nil.  It is not safe to modify mux after
-// the server has been started unless mux itself is safe for concurrent use by
-// multiple goroutines.
+// This function will panic if mux == nil, or if the options list an invalid
+// extension key (see ServerOptions.Extensions).  It is not safe to modify mux
+// after the server has been started unless mux itself is safe for concurrent
+// use by multiple goroutines.  To replace the assigner of a running server,
+// use the SetAssigner method.
 func NewServer(mux Assigner, opts *ServerOptions) *Server {
 	if mux == nil {
 		panic("nil assigner")
 	}
+	mustCheckExtensionKeys(opts.extensions())
 	s := &Server{
 		mux:     mux,
 		sem:     semaphore.NewWeighted(opts.concurrency()),
@@ -107,9 +138,30 @@ func NewServer(mux Assigner, opts *ServerOptions) *Server {
 		mu:      new(sync.Mutex),
 		start:   opts.startTime(),
 		builtin: opts.allowBuiltin(),
+		ics:     opts.interceptors(),
+		recover: opts.recoverPanics(),
+		pstack:  opts.panicStack(),
+		maxq:    opts.maxQueued(),
+		blockN:  opts.overloadPolicy() == BlockNotifications,
+		metrics: opts.metrics(),
+		tracer:  opts.tracer(),
+		limit:   opts.limiter(),
+		maxMsg:  opts.maxMessageSize(),
+		okey:    opts.orderKey(),
+		hub:     opts.hub(),
+		authz:   opts.authorize(),
+		xkeys:   opts.extensionKeys(),
+		ptime:   opts.propagateTimeouts(),
+		maxTO:   opts.maxTimeout(),
+		notifyM: opts.notifyMethodsChanged(),
+		dinfo:   opts.discoveryInfo(),
 		used:    make(map[string]context.CancelFunc),
 		call:    make(map[string]*Response),
+		order:   make(map[string]chan struct{}),
 		callID:  1,
+
+		slowWarn:  opts.slowRequestWarning(),
+		slowLimit: opts.slowRequestLimit(),
 	}
 	return s
 }
@@ -129,13 +181,16 @@ func (s *Server) Start(c channel.Channel) *Server {
 	if s.start.IsZero() {
 		s.start = time.Now().In(time.UTC)
 	}
-	serversActiveGauge.Add(1)
+	s.metrics.add(metricServersActive, 1)
 
 	// Reset all the I/O structures and start up the workers.
 	s.err = nil
+	s.draining = false
+	s.sess = newSession()
 
-	// Reset the signal channel.
+	// Reset the signal channels.
 	s.work = make(chan struct{}, 1)
+	s.room = make(chan struct{}, 1)
 
 	// s.wg waits for the maintenance goroutines for receiving input and
 	// processing the request queue. In addition, each request in flight adds a
@@ -204,10 +259,127 @@ func (s *Server) nextRequest() (func() error, error) {
 	ch := s.ch // capture
 
 	next, _ := s.inq.Pop()
+	s.nrun++
 	s.log("Dequeued request batch of length %d (qlen=%d)", len(next), s.inq.Len())
 
 	// Construct a dispatcher to run the handlers outside the lock.
-	return s.dispatchLocked(next, ch), nil
+	run := s.dispatchLocked(next, ch)
+	return func() error {
+		defer s.batchDone(len(next))
+		return run()
+	}, nil
+}
+
+// batchDone records the completion of a dispatched batch of n requests.
+func (s *Server) batchDone(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nrun--
+	s.queuedLocked(-n)
+	if s.ch != nil {
+		select {
+		case s.room <- struct{}{}:
+		default:
+		}
+	}
+	s.checkDrainedLocked()
+}
+
+// queuedLocked adds n to the count of pending requests. The caller must hold
+// s.mu.
+func (s *Server) queuedLocked(n int) {
+	s.qlen += n
+	s.metrics.add(metricRequestsQueued, int64(n))
+}
+
+// admitLocked checks whether the pending request limit permits batch to be
+// enqueued, and returns the portion of the batch that should be enqueued.
+// Calls that exceed the limit are rejected with an error, and notifications
+// are either discarded or wait for room, according to the overload policy.
+// The caller must hold s.mu, but the lock is released while waiting.
+//
+// A batch is always admitted if no other requests are pending, so that a
+// batch larger than the limit does not stall forever.
+func (s *Server) admitLocked(batch jmessages) jmessages {
+	if s.maxq <= 0 || s.qlen == 0 || s.qlen+len(batch) <= s.maxq {
+		return batch
+	}
+	var calls, notes jmessages
+	for _, req := range batch {
+		if req.isNotification() {
+			notes = append(notes, req)
+		} else {
+			calls = append(calls, req)
+		}
+	}
+	s.log("Server overloaded (qlen=%d, max=%d)", s.qlen, s.maxq)
+	s.metrics.add(metricRequestsDenied, int64(len(calls)))
+	s.rejectLocked(calls, errOverloaded)
+	if len(notes) == 0 {
+		return nil
+	} else if !s.blockN {
+		s.log("Discarding %d notifications: queue is full", len(notes))
+		s.metrics.add(metricRequestsDenied, int64(len(notes)))
+		return nil
+	}
+
+	// Reaching here, we have notifications to wait for.
+	for s.ch != nil && s.qlen != 0 && s.qlen+len(notes) > s.maxq {
+		s.mu.Unlock()
+		<-s.room
+		s.mu.Lock()
+	}
+	if s.ch == nil {
+		return nil // the server stopped while we were waiting
+	}
+	return notes
+}
+
+// limitLocked applies the rate limits of the server to batch, and returns the
+// portion of the batch that should be enqueued.  Calls that exceed the limit
+// are rejected with an error and notifications are discarded, or the batch is
+// delayed until it is within the limit, according to the rate limit policy.
+// The caller must hold s.mu, but the lock is released while waiting.
+func (s *Server) limitLocked(batch jmessages) jmessages {
+	if s.limit == nil || len(batch) == 0 {
+		return batch
+	}
+	now := time.Now()
+	var keep, drop jmessages
+	var wait, retry time.Duration
+	for _, req := range batch {
+		d := s.limit.take(req.M, now)
+		if d == 0 || s.limit.delay {
+			keep = append(keep, req)
+			wait = max(wait, d)
+		} else {
+			drop = append(drop, req)
+			retry = max(retry, d)
+		}
+	}
+	if len(drop) != 0 {
+		s.log("Rate limit exceeded for %d requests (retry after %v)", len(drop), retry)
+		s.metrics.add(metricRateLimited, int64(len(drop)))
+		s.rejectLocked(drop, errRateLimited.WithData(RetryAfter{Seconds: retry.Seconds()}))
+	}
+	if wait > 0 {
+		s.log("Rate limit exceeded; delaying %d requests for %v", len(keep), wait)
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		for room, done := s.room, false; !done && s.ch != nil; {
+			s.mu.Unlock()
+			select {
+			case <-timer.C:
+				done = true
+			case <-room: // woken early; this channel is closed when the server stops
+			}
+			s.mu.Lock()
+		}
+		if s.ch == nil {
+			return nil // the server stopped while we were waiting
+		}
+	}
+	return keep
 }
 
 // waitForBarrier blocks until all notification handlers that have been issued
@@ -233,6 +405,9 @@ func (s *Server) waitForBarrier(n int) {
 // has completed, to ensure that notifications are processed in a partial order
 // that respects order of receipt. Notifications within a batch are handled
 // concurrently.
+//
+// If the server has an ordering function, requests that share an ordering key
+// are also executed in order of receipt (see orderLocked).
 func (s *Server) dispatchLocked(next jmessages, ch sender) func() error {
 	// Resolve all the task handlers or record errors.
 	start := time.Now()
@@ -241,6 +416,7 @@ func (s *Server) dispatchLocked(next jmessages, ch sender) func() error {
 	// Ensure all notifications already issued have completed; see #24.
 	todo, notes := tasks.numToDo()
 	s.waitForBarrier(notes)
+	s.orderLocked(tasks)
 
 	return func() error {
 		var wg sync.WaitGroup
@@ -251,19 +427,10 @@ func (s *Server) dispatchLocked(next jmessages, ch sender) func() error {
 
 			todo--
 			if todo == 0 {
-				t.val, t.err = s.invoke(t.ctx, t.m, t.hreq)
-				if t.hreq.IsNotification() {
-					s.nbar.Done()
-				}
+				s.runTask(t)
 				break
 			}
-			t := t
-			wg.Go(func() {
-				t.val, t.err = s.invoke(t.ctx, t.m, t.hreq)
-				if t.hreq.IsNotification() {
-					s.nbar.Done()
-				}
-			})
+			wg.Go(func() { s.runTask(t) })
 		}
 
 		// Wait for all the handlers to return, then deliver any responses.
@@ -272,6 +439,48 @@ func (s *Server) dispatchLocked(next jmessages, ch sender) func() error {
 	}
 }
 
+// orderLocked links each task in ts that has an ordering key to the most
+// recent task previously dispatched with the same key, so that runTask will
+// wait for its predecessor to complete.  Because batches are dispatched in
+// order of receipt, this orders requests with the same key by arrival.  The
+// caller must hold s.mu.
+func (s *Server) orderLocked(ts tasks) {
+	if s.okey == nil {
+		return
+	}
+	for _, t := range ts {
+		if t.err != nil {
+			continue // this task will not be executed
+		}
+		key := s.okey(t.hreq)
+		if key == "" {
+			continue
+		}
+		t.key, t.after, t.done = key, s.order[key], make(chan struct{})
+		s.order[key] = t.done
+	}
+}
+
+// runTask invokes the handler for t and records its results, after waiting
+// for the previous task with the same ordering key (if any) to complete.
+func (s *Server) runTask(t *task) {
+	if t.after != nil {
+		<-t.after
+	}
+	t.val, t.err = s.invoke(t.ctx, t.m, t.hreq)
+	if t.hreq.IsNotification() {
+		s.nbar.Done()
+	}
+	if t.done != nil {
+		close(t.done)
+		s.mu.Lock()
+		if s.order[t.key] == t.done {
+			delete(s.order, t.key) // no successors are waiting
+		}
+		s.mu.Unlock()
+	}
+}
+
 // deliver cleans up completed responses and arranges their replies (if any) to
 // be sent back to the client.
 func (s *Server) deliver(rsps jmessages, ch sender, elapsed time.Duration) error {
@@ -292,7 +501,7 @@ func (s *Server) deliver(rsps jmessages, ch sender, elapsed time.Duration) error
 	}
 
 	nw, err := encode(ch, rsps)
-	bytesWrittenCount.Add(int64(nw))
+	s.metrics.add(metricBytesWritten, int64(nw))
 	return err
 }
 
@@ -307,7 +516,7 @@ func (s *Server) checkAndAssignLocked(next jmessages) tasks {
 	for _, req := range next {
 		fid := fixID(req.ID)
 		t := &task{
-			hreq:  &Request{id: fid, method: req.M, params: req.P},
+			hreq:  &Request{id: fid, method: req.M, params: req.P, ext: req.X},
 			batch: req.batch,
 		}
 		if req.err != nil {
@@ -347,7 +556,7 @@ func (s *Server) checkAndAssignLocked(next jmessages) tasks {
 		if t.err != nil {
 			s.log("Request check error for %q (params %q): %v",
 				t.hreq.method, string(t.hreq.params), t.err)
-			rpcErrorsCount.Add(1)
+			s.metrics.add(metricErrors, 1)
 		}
 	}
 	return ts
@@ -357,11 +566,21 @@ func (s *Server) checkAndAssignLocked(next jmessages) tasks {
 // whether this succeeded.
 func (s *Server) setContext(t *task, id string) {
 	t.ctx = inboundRequestKey.Attach(s.newctx(), t.hreq)
+	t.ctx = sessionKey.Attach(t.ctx, s.sess)
+	t.rext = new(respExt)
+	t.ctx = respExtKey.Attach(t.ctx, t.rext)
 
 	// Store the cancellation for a request that needs a reply, so that we can
-	// respond to cancellation requests.
+	// respond to cancellation requests.  If the client sent a time budget, the
+	// context also ends when the budget expires.
 	if id != "" {
-		ctx, cancel := context.WithCancel(t.ctx)
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if d := timeoutOf(t.hreq, s.maxTO); d > 0 {
+			ctx, cancel = context.WithTimeout(t.ctx, d)
+		} else {
+			ctx, cancel = context.WithCancel(t.ctx)
+		}
 		s.used[id] = cancel
 		t.ctx = ctx
 	}
@@ -371,13 +590,31 @@ func (s *Server) setContext(t *task, id string) {
 // the return value into JSON if there is one.
 func (s *Server) invoke(base context.Context, h Handler, req *Request) (json.RawMessage, error) {
 	ctx := serverKey.Attach(base, s)
-	if err := s.sem.Acquire(ctx, 1); err != nil {
-		return nil, err
+
+	// Built-in methods do not count against the concurrency limit, so that
+	// (for example) a cancellation is not stuck behind the requests it is
+	// meant to cancel.
+	if !s.isBuiltin(req.method) {
+		if err := s.sem.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+		defer s.sem.Release(1)
 	}
-	defer s.sem.Release(1)
 
+	ctx, end := startTrace(s.tracer, ctx, req)
 	s.rpcLog.LogRequest(ctx, req)
-	v, err := h(ctx, req)
+	start := time.Now()
+	var v any
+	err := s.authorize(ctx, req)
+	if err == nil {
+		hctx, stop := s.watch(ctx, req)
+		v, err = s.callHandler(hctx, h, req)
+		if stop() {
+			v, err = nil, errRequestTimeout
+		}
+	}
+	s.metrics.observe(req.method, ErrorCode(err), time.Since(start))
+	end(err)
 	if err != nil {
 		if req.IsNotification() {
 			s.log("Discarding error from notification to %q: %v", req.Method(), err)
@@ -388,6 +625,33 @@ func (s *Server) invoke(base context.Context, h Handler, req *Request) (json.Raw
 	return json.Marshal(v)
 }
 
+// callHandler calls h with the server's interceptors applied.  If panic
+// recovery is enabled, a panic from h is converted into an error.
+func (s *Server) callHandler(ctx context.Context, h Handler, req *Request) (_ any, err error) {
+	if s.recover {
+		defer func() {
+			if p := recover(); p != nil {
+				err = s.panicError(req, p)
+			}
+		}()
+	}
+	return intercept(h, s.ics)(ctx, req)
+}
+
+// panicError logs and counts a panic p recovered from the handler for req,
+// and returns an InternalError describing it.
+func (s *Server) panicError(req *Request, p any) error {
+	stack := debug.Stack()
+	s.metrics.add(metricHandlerPanics, 1)
+	s.log("Recovered panic in handler for %q: %v\n%s", req.Method(), p, stack)
+
+	jerr := Errorf(InternalError, "panic in handler: %v", p)
+	if s.pstack {
+		return jerr.WithData(string(stack))
+	}
+	return jerr
+}
+
 // ServerInfo returns an atomic snapshot of the current server info for s.
 func (s *Server) ServerInfo() *ServerInfo {
 	info := &ServerInfo{
@@ -398,12 +662,64 @@ func (s *Server) ServerInfo() *ServerInfo {
 	serverMetrics.Do(func(kv expvar.KeyValue) {
 		info.Metrics[kv.Key] = json.RawMessage(kv.Value.String())
 	})
-	if n, ok := s.mux.(Namer); ok {
+	info.Stats = s.metrics.Snapshot()
+	if s.hub != nil {
+		info.Topics = s.hub.Topics()
+	}
+	if n, ok := s.assigner().(Namer); ok {
 		info.Methods = n.Names()
 	}
 	return info
 }
 
+// SetAssigner replaces the assigner for s with mux.  Requests received after
+// SetAssigner returns are dispatched according to mux, while requests whose
+// handlers were already assigned finish using the previous assigner.  It is
+// safe to call SetAssigner while the server is running.
+//
+// If the NotifyMethodsChanged server option is true and the server is
+// running, SetAssigner also sends an "rpc.methodsChanged" notification to the
+// client.  Its parameters are an object whose "methods" field lists the new
+// method names, as reported by ServerInfo.
+//
+// This method will panic if mux == nil.
+func (s *Server) SetAssigner(mux Assigner) {
+	if mux == nil {
+		panic("nil assigner")
+	}
+	s.mu.Lock()
+	s.mux = mux
+	running := s.ch != nil
+	s.mu.Unlock()
+
+	if running && s.notifyM && s.allowP {
+		names := []string{"*"}
+		if n, ok := mux.(Namer); ok {
+			names = n.Names()
+		}
+		err := s.Notify(context.Background(), rpcMethodsChanged, methodsChanged{Methods: names})
+		if err != nil {
+			s.log("Notifying client of method changes: %v", err)
+		}
+	}
+}
+
+// methodsChanged is the parameters of an rpc.methodsChanged notification.
+type methodsChanged struct {
+	Methods []string `json:"methods"`
+}
+
+// assigner returns the current assigner for s.
+func (s *Server) assigner() Assigner {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mux
+}
+
+// Metrics returns the metrics recorded for s. If s shares its Metrics with
+// other servers, the result includes their metrics also.
+func (s *Server) Metrics() *Metrics { return s.metrics }
+
 // ErrPushUnsupported is returned by the Notify and Call methods if server
 // pushes are not enabled.
 var ErrPushUnsupported = errors.New("server push is not enabled")
@@ -471,14 +787,62 @@ func (s *Server) waitCallback(pctx context.Context, id string, p *Response) {
 	}
 }
 
+// CallbackBatch posts a batch of server-side requests to the client, as a
+// single JSON array, and blocks until all the replies are received, ctx ends,
+// or the client connection terminates.  The responses are returned in the same
+// order as the specs, omitting notifications.
+//
+// As with [Client.Batch], an error reported by CallbackBatch represents an
+// error in encoding or sending the batch.  Errors reported by the client, or
+// due to ctx ending before a reply was received, must be recovered from the
+// responses.  The same caveats apply as for [Server.Callback]: Unless s was
+// constructed with the AllowPush option set true, this method will always
+// report an error ([ErrPushUnsupported]) without sending anything, and if it
+// is called after the client connection is closed, it returns [ErrConnClosed].
+func (s *Server) CallbackBatch(ctx context.Context, specs []Spec) ([]*Response, error) {
+	if !s.allowP {
+		return nil, ErrPushUnsupported
+	} else if len(specs) == 0 {
+		return nil, errors.New("empty callback batch")
+	}
+	rsps, err := s.pushBatch(ctx, specs, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, rsp := range rsps {
+		rsp.wait()
+	}
+	return rsps, nil
+}
+
 func (s *Server) pushReq(ctx context.Context, wantID bool, method string, params any) (rsp *Response, _ error) {
-	var bits []byte
-	if params != nil {
-		v, err := json.Marshal(params)
-		if err != nil {
+	rsps, err := s.pushBatch(ctx, []Spec{{Method: method, Params: params, Notify: !wantID}}, false)
+	if len(rsps) != 0 {
+		rsp = rsps[0]
+	}
+	return rsp, err
+}
+
+// pushBatch sends the requests described by specs to the client, and returns
+// responses that are waiting for the replies to the calls among them.  If
+// batch is true, the requests are sent as a JSON array even if there is only
+// one.
+func (s *Server) pushBatch(ctx context.Context, specs []Spec, batch bool) ([]*Response, error) {
+	msgs := make(jmessages, len(specs))
+	for i, spec := range specs {
+		var bits []byte
+		if spec.Params != nil {
+			v, err := json.Marshal(spec.Params)
+			if err != nil {
+				return nil, err
+			}
+			bits = v
+		}
+		msg := &jmessage{M: spec.Method, P: bits, batch: batch}
+		if err := injectExtensions(ctx, spec.Extensions, msg); err != nil {
 			return nil, err
 		}
-		bits = v
+		msgs[i] = msg
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -486,35 +850,38 @@ func (s *Server) pushReq(ctx context.Context, wantID bool, method string, params
 		return nil, ErrConnClosed
 	}
 
-	kind := "notification"
-	var jid json.RawMessage
-	if wantID {
-		kind = "call"
-		id := strconv.FormatInt(s.callID, 10)
-		s.callID++
+	var rsps []*Response
+	for i, msg := range msgs {
+		kind := "notification"
+		if !specs[i].Notify {
+			kind = "call"
+			id := strconv.FormatInt(s.callID, 10)
+			s.callID++
+
+			cbctx, cancel := context.WithCancel(ctx)
+			msg.ID = json.RawMessage(id)
+			rsp := &Response{
+				ch:     make(chan *jmessage, 1),
+				id:     id,
+				cancel: cancel,
+			}
+			s.call[id] = rsp
+			go s.waitCallback(cbctx, id, rsp)
+			s.metrics.add(metricCallsPushed, 1)
+			rsps = append(rsps, rsp)
 
-		cbctx, cancel := context.WithCancel(ctx)
-		jid = json.RawMessage(id)
-		rsp = &Response{
-			ch:     make(chan *jmessage, 1),
-			id:     id,
-			cancel: cancel,
+			if s.ptime {
+				injectTimeout(ctx, msg)
+			}
+		} else {
+			s.metrics.add(metricNotesPushed, 1)
 		}
-		s.call[id] = rsp
-		go s.waitCallback(cbctx, id, rsp)
-		rpcCallsPushed.Add(1)
-	} else {
-		rpcNotificationsPushed.Add(1)
+		s.log("Posting server %s %q %s", kind, msg.M, string(msg.P))
+		injectTrace(s.tracer, ctx, msg)
 	}
-
-	s.log("Posting server %s %q %s", kind, method, string(bits))
-	nw, err := encode(s.ch, jmessages{{
-		ID: jid,
-		M:  method,
-		P:  bits,
-	}})
-	bytesWrittenCount.Add(int64(nw))
-	return rsp, err
+	nw, err := encode(s.ch, msgs)
+	s.metrics.add(metricBytesWritten, int64(nw))
+	return rsps, err
 }
 
 // Stop shuts down the server. All in-progress call handlers are cancelled. It
@@ -526,11 +893,68 @@ func (s *Server) Stop() {
 	s.stopLocked(errServerStopped)
 }
 
+// Shutdown gracefully shuts down the server.  The server first stops
+// accepting new requests: Any call received after Shutdown begins is rejected
+// with an error, and any notification is discarded.  Shutdown then waits for
+// all the requests already queued or in flight to complete and deliver their
+// responses, before closing the channel to the client.
+//
+// If ctx ends before the server has finished draining, Shutdown stops the
+// server as [Server.Stop] does, cancelling any requests still in progress, and
+// returns the error from ctx.  Otherwise Shutdown returns nil.
+//
+// It is safe to call Shutdown multiple times, or concurrently with Stop; only
+// the first to stop the server takes effect. Responses to pending server
+// callbacks are still delivered while the server is draining.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.ch == nil {
+		s.mu.Unlock()
+		return nil // nothing is running
+	}
+	if !s.draining {
+		s.log("Server draining for shutdown")
+		s.draining = true
+		s.drain = make(chan struct{})
+		s.checkDrainedLocked()
+	}
+	done := s.drain // may be nil if draining is already complete
+	s.mu.Unlock()
+
+	var err error
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.log("Server did not drain before shutdown: %v", err)
+		s.stopLocked(errServerStopped)
+	} else {
+		s.stopLocked(errServerDrained)
+	}
+	return err
+}
+
+// checkDrainedLocked signals completion of draining if the server is draining
+// and has no queued or in-flight requests remaining. The caller must hold s.mu.
+func (s *Server) checkDrainedLocked() {
+	if s.drain != nil && s.inq.IsEmpty() && s.nrun == 0 {
+		close(s.drain)
+		s.drain = nil
+	}
+}
+
 // ServerStatus describes the status of a stopped server.
 //
 // A server is said to have succeeded if it stopped because the client channel
-// closed or because its Stop method was called. On success, Err == nil, and
-// the flag fields indicate the reason why the server exited.
+// closed or because its Stop or Shutdown method was called. On success, Err ==
+// nil, and the flag fields indicate the reason why the server exited.
 // Otherwise, Err != nil is the error value that caused the server to exit.
 type ServerStatus struct {
 	Err error // the error that caused the server to stop (nil on success)
@@ -539,6 +963,10 @@ type ServerStatus struct {
 	// At most one of these fields will be true.
 	Stopped bool // server exited because Stop was called
 	Closed  bool // server exited because the client channel closed
+	Drained bool // server exited because Shutdown completed draining
+
+	// The session of the server, which is closed (see [Session]).
+	Session *Session
 }
 
 // Success reports whether the server exited without error.
@@ -553,13 +981,18 @@ func (s *Server) WaitStatus() ServerStatus {
 	if !s.inq.IsEmpty() {
 		panic("s.inq is not empty at shutdown")
 	}
-	stat := ServerStatus{Err: s.err}
+	s.mu.Lock()
+	stat := ServerStatus{Err: s.err, Session: s.sess}
+	s.mu.Unlock()
 	if s.err == io.EOF || channel.IsErrClosing(s.err) {
 		stat.Err = nil
 		stat.Closed = true
 	} else if s.err == errServerStopped {
 		stat.Err = nil
 		stat.Stopped = true
+	} else if s.err == errServerDrained {
+		stat.Err = nil
+		stat.Drained = true
 	}
 	return stat
 }
@@ -594,11 +1027,15 @@ func (s *Server) stopLocked(err error) {
 		}
 		return true
 	})
+	var drop int
+	s.inq.Each(func(cur jmessages) bool { drop += len(cur); return true })
 	s.inq.Clear()
 	for _, elt := range keep {
 		s.inq.Add(jmessages{elt})
 	}
+	s.queuedLocked(len(keep) - drop)
 	close(s.work)
+	close(s.room)
 
 	// Cancel any in-flight requests that made it out of the queue, and
 	// terminate any pending callback invocations.
@@ -615,9 +1052,21 @@ func (s *Server) stopLocked(err error) {
 		panic("s.used is not empty at shutdown")
 	}
 
+	// Release any caller of Shutdown waiting for the server to drain.
+	if s.drain != nil {
+		close(s.drain)
+		s.drain = nil
+	}
+
+	// Close the session, and discard any subscriptions held by the client.
+	s.sess.close()
+	if s.hub != nil {
+		s.hub.remove(s)
+	}
+
 	s.err = err
 	s.ch = nil
-	serversActiveGauge.Add(-1)
+	s.metrics.add(metricServersActive, -1)
 }
 
 // read is the main receiver loop, decoding requests from the client and adding
@@ -631,11 +1080,17 @@ func (s *Server) read(ch receiver) {
 		var in jmessages
 		var derr error
 		bits, err := ch.Recv()
-		bytesReadCount.Add(int64(len(bits)))
+		s.metrics.add(metricBytesRead, int64(len(bits)))
+		if s.isTooLarge(bits, err) {
+			s.mu.Lock()
+			s.pushErrorLocked(errMessageTooLarge)
+			s.mu.Unlock()
+			continue
+		}
 		if err == nil || (err == io.EOF && len(bits) != 0) {
 			err = nil
-			derr = in.parseJSON(bits)
-			rpcRequestsCount.Add(int64(len(in)))
+			derr = in.parseJSON(bits, s.xkeys...)
+			s.metrics.add(metricRequests, int64(len(in)))
 		}
 		s.mu.Lock()
 		if err != nil { // receive failure; shut down
@@ -650,9 +1105,12 @@ func (s *Server) read(ch receiver) {
 			// Filter out response messages. It's possible that the entire batch
 			// was responses, so re-check the length after doing this.
 			keep := s.filterBatchLocked(in)
-			if len(keep) != 0 {
+			if len(keep) != 0 && s.draining {
+				s.rejectLocked(keep, errServerDraining)
+			} else if keep = s.admitLocked(s.limitLocked(keep)); len(keep) != 0 {
 				s.log("Received request batch of size %d (qlen=%d)", len(keep), s.inq.Len())
 				s.inq.Add(keep)
+				s.queuedLocked(len(keep))
 				if s.inq.Len() == 1 { // the queue was empty
 					s.signal()
 				}
@@ -662,6 +1120,15 @@ func (s *Server) read(ch receiver) {
 	}
 }
 
+// isTooLarge reports whether the message and error from a Recv indicate the
+// message exceeds the maximum size.
+func (s *Server) isTooLarge(msg []byte, err error) bool {
+	if _, ok := err.(*channel.RecordTooLargeError); ok {
+		return true
+	}
+	return err == nil && s.maxMsg > 0 && len(msg) > s.maxMsg
+}
+
 // filterBatchLocked removes and handles any response messages from next,
 // dispatching replies to pending callbacks as required. The remainder is
 // returned.  The caller must hold s.mu, and must re-check that the result is
@@ -703,17 +1170,35 @@ type ServerInfo struct {
 
 	// When the server started.
 	StartTime time.Time `json:"startTime,omitzero"`
+
+	// Metrics recorded for this server (see [Server.Metrics]).
+	Stats *MetricsSnapshot `json:"stats,omitempty"`
+
+	// The number of subscribers to each topic of the server's hub, across all
+	// the servers sharing the hub (see [Hub]).
+	Topics map[string]int `json:"topics,omitempty"`
 }
 
 // assignLocked returns a Handler to handle the specified name, or nil.  The
 // caller must hold s.mu.
 func (s *Server) assignLocked(ctx context.Context, name string) Handler {
-	if s.builtin && strings.HasPrefix(name, "rpc.") {
+	if s.isBuiltin(name) {
 		switch name {
 		case rpcServerInfo:
 			return func(context.Context, *Request) (any, error) {
 				return s.ServerInfo(), nil
 			}
+		case rpcCancel:
+			return s.handleRPCCancel
+		case rpcDiscover:
+			return func(context.Context, *Request) (any, error) {
+				return s.Discover(), nil
+			}
+		case rpcSubscribe, rpcUnsubscribe:
+			if s.hub != nil {
+				return s.handleRPCSubscribe
+			}
+			return nil
 		default:
 			return nil // reserved
 		}
@@ -721,6 +1206,25 @@ func (s *Server) assignLocked(ctx context.Context, name string) Handler {
 	return s.mux.Assign(ctx, name)
 }
 
+// isBuiltin reports whether name is handled by s as a built-in method.
+func (s *Server) isBuiltin(name string) bool {
+	return s.builtin && strings.HasPrefix(name, "rpc.")
+}
+
+// handleRPCCancel implements the rpc.cancel built-in method.  Its parameters
+// are an array of request IDs, each of which is cancelled as if by a call to
+// CancelRequest. IDs that do not match a pending request are ignored.
+func (s *Server) handleRPCCancel(_ context.Context, req *Request) (any, error) {
+	var ids []json.RawMessage
+	if err := req.UnmarshalParams(&ids); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		s.CancelRequest(string(id))
+	}
+	return nil, nil
+}
+
 // pushErrorLocked reports an error for the given request ID directly back to
 // the client, bypassing the normal request handling mechanism.  The caller
 // must hold s.mu when calling this method.
@@ -737,8 +1241,36 @@ func (s *Server) pushErrorLocked(err error) {
 		ID: json.RawMessage("null"),
 		E:  jerr,
 	}})
-	rpcErrorsCount.Add(1)
-	bytesWrittenCount.Add(int64(nw))
+	s.metrics.add(metricErrors, 1)
+	s.metrics.add(metricBytesWritten, int64(nw))
+	if err != nil {
+		s.log("Writing error response: %v", err)
+	}
+}
+
+// rejectLocked reports jerr to the client for each call in batch, without
+// executing any of them. Notifications in the batch are discarded.  The caller
+// must hold s.mu.
+func (s *Server) rejectLocked(batch jmessages, jerr *Error) {
+	var rsps jmessages
+	for _, req := range batch {
+		if req.isNotification() {
+			s.log("Discarding notification %q: %v", req.M, jerr)
+			continue
+		}
+		id := fixID(req.ID)
+		if id == nil {
+			id = json.RawMessage("null")
+		}
+		rsps = append(rsps, &jmessage{ID: id, E: jerr, batch: req.batch})
+	}
+	if len(rsps) == 0 {
+		return
+	}
+	s.log("Rejected %d requests: %v", len(rsps), jerr)
+	nw, err := encode(s.ch, rsps)
+	s.metrics.add(metricErrors, int64(len(rsps)))
+	s.metrics.add(metricBytesWritten, int64(nw))
 	if err != nil {
 		s.log("Writing error response: %v", err)
 	}
@@ -763,6 +1295,11 @@ type task struct {
 	ctx   context.Context // the context passed to the handler
 	hreq  *Request        // the request passed to the handler
 	batch bool            // whether the request was part of a batch
+	rext  *respExt        // extension fields for the response
+
+	key   string        // the ordering key, if any
+	after chan struct{} // if not nil, closed when the predecessor completes
+	done  chan struct{} // if not nil, closed when this task completes
 
 	val json.RawMessage // the result value (when complete)
 	err error           // the error value (when complete)
@@ -787,6 +1324,9 @@ func (ts tasks) responses(rpcLog RPCLogger) jmessages {
 			}
 		}
 		rsp := &jmessage{ID: task.hreq.id, batch: task.batch}
+		if task.rext != nil {
+			rsp.X = task.rext.get()
+		}
 		if rsp.ID == nil {
 			rsp.ID = json.RawMessage("null")
 		}
@@ -807,6 +1347,7 @@ func (ts tasks) responses(rpcLog RPCLogger) jmessages {
 			id:     string(rsp.ID),
 			err:    rsp.E,
 			result: rsp.R,
+			ext:    rsp.X,
 		})
 		rsps = append(rsps, rsp)
 	}
@@ -7,9 +7,11 @@ import (
 	"context"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/creachadair/jrpc2"
 	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/mds/mctx"
 )
 
 // Service is the interface used by the Loop function to start up a server.
@@ -26,7 +28,8 @@ type Service interface {
 
 	// This method is called when the server for this service has exited.
 	// The arguments are the assigner returned by the Assigner method and the
-	// server exit status.
+	// server exit status, whose Session field holds the per-connection values
+	// stored by the handlers.
 	Finish(jrpc2.Assigner, jrpc2.ServerStatus)
 }
 
@@ -47,6 +50,17 @@ type Accepter interface {
 	Accept(ctx context.Context) (channel.Channel, error)
 }
 
+// A PeerAccepter is an [Accepter] that can also report the address of the
+// peer for each connection it accepts.  The accepter returned by NetAccepter
+// implements this interface.
+type PeerAccepter interface {
+	Accepter
+
+	// AcceptPeer behaves as Accept, and also returns the address of the
+	// peer, or nil if the address is unknown.
+	AcceptPeer(ctx context.Context) (channel.Channel, net.Addr, error)
+}
+
 // NetAccepter adapts a [net.Listener] to the Accepter interface, using f as
 // the channel framing.
 func NetAccepter(lst net.Listener, f channel.Framing) Accepter {
@@ -59,6 +73,12 @@ type netAccepter struct {
 }
 
 func (n netAccepter) Accept(ctx context.Context) (channel.Channel, error) {
+	ch, _, err := n.AcceptPeer(ctx)
+	return ch, err
+}
+
+// AcceptPeer implements the [PeerAccepter] interface.
+func (n netAccepter) AcceptPeer(ctx context.Context) (channel.Channel, net.Addr, error) {
 	// A net.Listener does not obey a context, so simulate it by closing the
 	// listener if ctx ends.
 	ok := make(chan struct{})
@@ -74,9 +94,9 @@ func (n netAccepter) Accept(ctx context.Context) (channel.Channel, error) {
 
 	conn, err := n.Listener.Accept()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return n.newChannel(conn, conn), nil
+	return n.newChannel(conn, conn), conn.RemoteAddr(), nil
 }
 
 // Loop obtains connections from lst and starts a server for each using a
@@ -85,9 +105,12 @@ func (n netAccepter) Accept(ctx context.Context) (channel.Channel, error) {
 //
 // If lst is closed or otherwise reports an error, the loop will terminate.
 // The error will be reported to the caller of Loop once any active servers
-// have returned. In addition, if ctx ends, any active servers will be stopped.
+// have returned. In addition, if ctx ends, any active servers will be stopped,
+// or shut down gracefully if the DrainTimeout option is set.
 func Loop(ctx context.Context, lst Accepter, newService func() Service, opts *LoopOptions) error {
 	serverOpts := opts.serverOpts()
+	drain := opts.drainTimeout()
+	sessions := opts.sessions()
 	log := func(string, ...any) {}
 	if serverOpts != nil && serverOpts.Logger != nil {
 		log = serverOpts.Logger.Printf
@@ -95,7 +118,7 @@ func Loop(ctx context.Context, lst Accepter, newService func() Service, opts *Lo
 
 	var wg sync.WaitGroup
 	for {
-		ch, err := lst.Accept(ctx)
+		ch, peer, err := accept(ctx, lst)
 		if err != nil {
 			if channel.IsErrClosing(err) {
 				err = nil
@@ -116,10 +139,21 @@ func Loop(ctx context.Context, lst Accepter, newService func() Service, opts *Lo
 			sctx, cancel := context.WithCancel(ctx)
 			defer cancel()
 
-			srv := jrpc2.NewServer(assigner, serverOpts).Start(ch)
-			go func() { <-sctx.Done(); srv.Stop() }()
+			srv := jrpc2.NewServer(assigner, withPeer(serverOpts, peer)).Start(ch)
+			sess := sessions.add(srv, peer)
+			go func() {
+				<-sctx.Done()
+				if drain <= 0 {
+					srv.Stop()
+					return
+				}
+				dctx, cancel := context.WithTimeout(context.Background(), drain)
+				defer cancel()
+				srv.Shutdown(dctx)
+			}()
 
 			stat := srv.WaitStatus()
+			sessions.remove(sess, stat)
 			svc.Finish(assigner, stat)
 			if stat.Err != nil {
 				log("Server exit: %v", stat.Err)
@@ -128,12 +162,58 @@ func Loop(ctx context.Context, lst Accepter, newService func() Service, opts *Lo
 	}
 }
 
+// accept obtains a connection from lst, along with the peer address if lst
+// is able to report it.
+func accept(ctx context.Context, lst Accepter) (channel.Channel, net.Addr, error) {
+	if pa, ok := lst.(PeerAccepter); ok {
+		return pa.AcceptPeer(ctx)
+	}
+	ch, err := lst.Accept(ctx)
+	return ch, nil, err
+}
+
+// PeerFromContext returns the address of the peer for the connection served by
+// the handler with context ctx, or nil if the address is unknown. The [Loop]
+// function populates this value for the servers it starts, if its Accepter
+// implements [PeerAccepter].
+func PeerFromContext(ctx context.Context) net.Addr { return peerKey.Lookup(ctx).Get() }
+
+var peerKey = mctx.New[net.Addr]("peer")
+
+// withPeer returns a copy of opts whose request contexts carry peer, or opts
+// itself if peer == nil.
+func withPeer(opts *jrpc2.ServerOptions, peer net.Addr) *jrpc2.ServerOptions {
+	if peer == nil {
+		return opts
+	}
+	var cp jrpc2.ServerOptions
+	if opts != nil {
+		cp = *opts
+	}
+	base := cp.NewContext
+	if base == nil {
+		base = context.Background
+	}
+	cp.NewContext = func() context.Context { return peerKey.Attach(base(), peer) }
+	return &cp
+}
+
 // LoopOptions control the behaviour of the Loop function.  A nil *LoopOptions
 // provides default values as described.
 type LoopOptions struct {
 	// If non-nil, these options are used when constructing the server to
 	// handle requests on an inbound connection.
 	ServerOptions *jrpc2.ServerOptions
+
+	// If positive, when the context governing the loop ends, each active
+	// server is shut down gracefully, and allowed up to this long to finish
+	// its queued and in-flight requests before it is stopped. Otherwise,
+	// active servers are stopped immediately.
+	DrainTimeout time.Duration
+
+	// If non-nil, each server started by the loop is registered here for as
+	// long as it is running.
+	Sessions *Sessions
 }
 
 func (o *LoopOptions) serverOpts() *jrpc2.ServerOptions {
@@ -142,3 +222,17 @@ func (o *LoopOptions) serverOpts() *jrpc2.ServerOptions {
 	}
 	return o.ServerOptions
 }
+
+func (o *LoopOptions) sessions() *Sessions {
+	if o == nil {
+		return nil
+	}
+	return o.Sessions
+}
+
+func (o *LoopOptions) drainTimeout() time.Duration {
+	if o == nil {
+		return 0
+	}
+	return o.DrainTimeout
+}
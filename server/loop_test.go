@@ -4,6 +4,7 @@ package server_test
 
 import (
 	"context"
+	"errors"
 	"math/rand"
 	"net"
 	"sync"
@@ -166,6 +167,52 @@ func TestLoop_cancelServers(t *testing.T) {
 	})
 }
 
+// Test that cancelling a loop with a drain timeout lets pending calls finish.
+func TestLoop_drainServers(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+
+		release := make(chan struct{})
+		n, lst := mustListen(t)
+		errc := make(chan error, 1)
+		go func() {
+			defer close(errc)
+			errc <- server.Loop(ctx, server.NetAccepter(lst, newChan), server.Static(handler.Map{
+				"Test": handler.New(func(context.Context) (string, error) {
+					<-release // block until released, ignoring cancellation
+					return "OK", nil
+				}),
+			}), &server.LoopOptions{DrainTimeout: time.Minute})
+		}()
+		cli := mustDial(t, n, lst.Addr())
+		defer cli.Close()
+
+		var rsp string
+		var cerr error
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			cerr = cli.CallResult(t.Context(), "Test", nil, &rsp)
+		}()
+
+		synctest.Wait()
+		cancel() // this should begin draining the server
+		synctest.Wait()
+		close(release)
+
+		<-done
+		if cerr != nil {
+			t.Errorf("Call failed: %v", cerr)
+		} else if rsp != "OK" {
+			t.Errorf("Call: got %q, want OK", rsp)
+		}
+		if err := <-errc; err != nil {
+			t.Errorf("Loop result: %v", err)
+		}
+	})
+}
+
 // Test that concurrent clients against the same server work sanely.
 func TestLoop(t *testing.T) {
 	tests := []struct {
@@ -215,3 +262,120 @@ func TestLoop(t *testing.T) {
 		})
 	}
 }
+
+// Test that a session registry tracks the servers started by the loop.
+func TestLoop_sessions(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+
+		ended := make(chan int64, 2)
+		sessions := &server.Sessions{
+			OnEnd: func(sess *server.Session, _ jrpc2.ServerStatus) { ended <- sess.ID },
+		}
+		n, lst := mustListen(t)
+		errc := make(chan error, 1)
+		go func() {
+			defer close(errc)
+			errc <- server.Loop(ctx, server.NetAccepter(lst, newChan), testStatic, &server.LoopOptions{
+				ServerOptions: &jrpc2.ServerOptions{AllowPush: true},
+				Sessions:      sessions,
+			})
+		}()
+
+		var mu sync.Mutex
+		var notes []string
+		var clients []*jrpc2.Client
+		for range 2 {
+			conn, err := n.Dial(lst.Addr().Network(), lst.Addr().String())
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			cli := jrpc2.NewClient(newChan(conn, conn), &jrpc2.ClientOptions{
+				OnNotify: func(req *jrpc2.Request) {
+					mu.Lock()
+					defer mu.Unlock()
+					notes = append(notes, req.Method())
+				},
+			})
+			defer cli.Close()
+			clients = append(clients, cli)
+		}
+		synctest.Wait()
+
+		live := sessions.List()
+		if len(live) != 2 {
+			t.Fatalf("List: got %d sessions, want 2", len(live))
+		}
+		for _, sess := range live {
+			if sess.Peer == nil {
+				t.Errorf("Session %d: missing peer address", sess.ID)
+			}
+			if got := sessions.Get(sess.ID); got != sess {
+				t.Errorf("Get(%d): got %+v, want %+v", sess.ID, got, sess)
+			}
+		}
+
+		if err := sessions.Broadcast(ctx, "hello", nil); err != nil {
+			t.Errorf("Broadcast: unexpected error: %v", err)
+		}
+		synctest.Wait()
+		if len(notes) != 2 {
+			t.Errorf("Broadcast: got notifications %q, want 2", notes)
+		}
+
+		// Kicking a session stops its server and removes it from the registry.
+		kick := live[0].ID
+		if !sessions.Kick(kick) {
+			t.Errorf("Kick(%d): session not found", kick)
+		}
+		if got := <-ended; got != kick {
+			t.Errorf("Ended session: got %d, want %d", got, kick)
+		}
+		if got := sessions.Len(); got != 1 {
+			t.Errorf("Len after kick: got %d, want 1", got)
+		}
+		if sessions.Kick(kick) {
+			t.Errorf("Kick(%d): found session after it ended", kick)
+		}
+		if _, err := clients[1].Call(ctx, "Test", nil); err != nil {
+			t.Errorf("Call on remaining session: %v", err)
+		}
+
+		cancel()
+		if err := <-errc; err != nil {
+			t.Errorf("Loop result: %v", err)
+		}
+		if got := <-ended; got != live[1].ID {
+			t.Errorf("Ended session: got %d, want %d", got, live[1].ID)
+		}
+	})
+}
+
+// Test that handlers started by the loop can see the address of their peer.
+func TestLoop_peer(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		n, lst := mustListen(t)
+		errc := mustServe(t, t.Context(), lst, server.Static(handler.Map{
+			"Peer": handler.New(func(ctx context.Context) (string, error) {
+				if addr := server.PeerFromContext(ctx); addr != nil {
+					return addr.Network(), nil
+				}
+				return "", errors.New("no peer address")
+			}),
+		}))
+		cli := mustDial(t, n, lst.Addr())
+
+		var got string
+		if err := cli.CallResult(t.Context(), "Peer", nil, &got); err != nil {
+			t.Errorf("Call(Peer): unexpected error: %v", err)
+		} else if got != "tcp" {
+			t.Errorf("Call(Peer): got %q, want tcp", got)
+		}
+		cli.Close()
+		lst.Close()
+		if err := <-errc; err != nil {
+			t.Errorf("Server exit failed: %v", err)
+		}
+	})
+}
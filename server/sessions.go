@@ -0,0 +1,120 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+)
+
+// A Session describes the server for a single connection accepted by [Loop].
+type Session struct {
+	ID     int64         // unique among the sessions of a registry
+	Server *jrpc2.Server // the server for the connection
+	Start  time.Time     // when the session started
+	Peer   net.Addr      // the address of the peer, or nil if unknown
+}
+
+// Sessions is a registry of the live sessions started by [Loop].  To use a
+// registry, set the Sessions field of the [LoopOptions].  A zero Sessions is
+// ready for use, and is safe for concurrent use by multiple goroutines.
+//
+// A registry may be shared by multiple loops, in which case it reports the
+// sessions of all of them.
+type Sessions struct {
+	// If set, this function is called after each session ends, and has been
+	// removed from the registry, with the exit status of its server.
+	OnEnd func(*Session, jrpc2.ServerStatus)
+
+	mu   sync.Mutex
+	last int64
+	live map[int64]*Session
+}
+
+// add registers a new session for srv, and returns it.
+func (s *Sessions) add(srv *jrpc2.Server, peer net.Addr) *Session {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.live == nil {
+		s.live = make(map[int64]*Session)
+	}
+	s.last++
+	sess := &Session{ID: s.last, Server: srv, Start: time.Now(), Peer: peer}
+	s.live[sess.ID] = sess
+	return sess
+}
+
+// remove removes sess from the registry and reports its exit status.
+func (s *Sessions) remove(sess *Session, stat jrpc2.ServerStatus) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	delete(s.live, sess.ID)
+	s.mu.Unlock()
+	if s.OnEnd != nil {
+		s.OnEnd(sess, stat)
+	}
+}
+
+// Len reports the number of live sessions.
+func (s *Sessions) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.live)
+}
+
+// List returns the live sessions, in order of ID.
+func (s *Sessions) List() []*Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.SortedFunc(maps.Values(s.live), func(a, b *Session) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+// Get returns the live session with the given ID, or nil if there is none.
+func (s *Sessions) Get(id int64) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.live[id]
+}
+
+// Broadcast posts a server notification to the client of each live session.
+// Sessions whose connections have closed are skipped silently; other failures
+// are combined in the error returned.  The servers must have the AllowPush
+// option enabled.
+func (s *Sessions) Broadcast(ctx context.Context, method string, params any) error {
+	var errs []error
+	for _, sess := range s.List() {
+		err := sess.Server.Notify(ctx, method, params)
+		if err != nil && !errors.Is(err, jrpc2.ErrConnClosed) {
+			errs = append(errs, fmt.Errorf("session %d: %w", sess.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Kick stops the server for the live session with the given ID, closing its
+// connection, and reports whether such a session was found.  The session is
+// removed from the registry once its server has exited.
+func (s *Sessions) Kick(id int64) bool {
+	sess := s.Get(id)
+	if sess == nil {
+		return false
+	}
+	sess.Server.Stop()
+	return true
+}
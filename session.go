@@ -0,0 +1,105 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jrpc2
+
+import (
+	"context"
+	"sync"
+
+	"github.com/creachadair/mds/mctx"
+)
+
+// A Session stores values associated with a single connection to a [Server],
+// such as the identity of a client that has logged in.  Each time a server is
+// started, it creates a new empty Session, which its handlers can obtain from
+// their context using [SessionFromContext].  A Session is safe for concurrent
+// use by multiple goroutines.
+//
+// When the server stops, its session is closed: The values it holds remain
+// available, for example to a server.Service via the Session field of the
+// [ServerStatus], but changes to a closed session have no effect.
+type Session struct {
+	mu     sync.Mutex
+	vals   map[any]any
+	closed bool
+}
+
+func newSession() *Session { return &Session{vals: make(map[any]any)} }
+
+// SessionFromContext returns the session of the server associated with the
+// context passed to a [Handler] by a [Server], or nil if ctx does not have a
+// session.
+func SessionFromContext(ctx context.Context) *Session { return sessionKey.Lookup(ctx).Get() }
+
+var sessionKey = mctx.New[*Session]("session")
+
+// Get returns the value associated with key in s, and reports whether it was
+// present.
+func (s *Session) Get(key any) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.vals[key]
+	return v, ok
+}
+
+// Set associates key with value in s, replacing any previous value.  The key
+// must be comparable.  Set has no effect if s is closed.
+func (s *Session) Set(key, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.vals[key] = value
+	}
+}
+
+// Delete removes the value associated with key from s, if present.  Delete
+// has no effect if s is closed.
+func (s *Session) Delete(key any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		delete(s.vals, key)
+	}
+}
+
+// Closed reports whether s has been closed because its server stopped.
+func (s *Session) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// close marks s as closed, so that it will no longer accept changes.
+func (s *Session) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+// A SessionKey is a typed key for values stored in a [Session].  Distinct
+// keys do not collide, even if they have the same name.
+type SessionKey[T any] struct{ name *string }
+
+// NewSessionKey constructs a new unique key for values of type T.  The name is
+// used only for diagnostics.
+func NewSessionKey[T any](name string) SessionKey[T] { return SessionKey[T]{name: &name} }
+
+// String returns the diagnostic name of k.
+func (k SessionKey[T]) String() string { return *k.name }
+
+// Get returns the value of k in s, and reports whether it was present.  If k
+// is not present, Get returns a zero value of T.
+func (k SessionKey[T]) Get(s *Session) (T, bool) {
+	v, ok := s.Get(k)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return v.(T), true
+}
+
+// Set sets the value of k in s to v.
+func (k SessionKey[T]) Set(s *Session, v T) { s.Set(k, v) }
+
+// Delete removes the value of k from s.
+func (k SessionKey[T]) Delete(s *Session) { s.Delete(k) }
@@ -0,0 +1,61 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jrpc2
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+)
+
+// timeoutKey is the name of the non-standard message field that carries the
+// remaining time budget of a call, in milliseconds, when timeout propagation
+// is enabled:
+//
+//	{"jsonrpc":"2.0", "id":1, "method":"M", "timeout":1500}
+const timeoutKey = "timeout"
+
+// injectTimeout attaches the time remaining before the deadline of ctx (if
+// any) to msg.  If ctx has already expired, the budget is reported as 1ms so
+// that the receiver does not treat it as unbounded.
+func injectTimeout(ctx context.Context, msg *jmessage) {
+	dl, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	ms := max(time.Until(dl).Milliseconds(), 1)
+	if msg.X == nil {
+		msg.X = make(map[string]json.RawMessage)
+	}
+	msg.X[timeoutKey] = json.RawMessage(strconv.FormatInt(ms, 10))
+}
+
+// timeoutOf returns the time budget attached to req, capped at limit if limit
+// > 0, or 0 if req does not have a valid time budget.
+func timeoutOf(req *Request, limit time.Duration) time.Duration {
+	var ms int64
+	if raw, ok := req.ext[timeoutKey]; !ok {
+		return 0
+	} else if json.Unmarshal(raw, &ms) != nil || ms <= 0 {
+		return 0 // N.B. ignore invalid values
+	}
+	if ms > int64(math.MaxInt64/time.Millisecond) {
+		ms = int64(math.MaxInt64 / time.Millisecond) // N.B. avoid overflow
+	}
+	d := time.Duration(ms) * time.Millisecond
+	if limit > 0 && d > limit {
+		return limit
+	}
+	return d
+}
+
+// timeoutKeys returns the extension keys to accept from the wire if timeout
+// propagation is enabled.
+func timeoutKeys(enabled bool) []string {
+	if !enabled {
+		return nil
+	}
+	return []string{timeoutKey}
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jrpc2
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// traceKey is the name of the non-standard message field that carries trace
+// context, when tracing is enabled.
+const traceKey = "trace"
+
+// TraceContext carries distributed trace context with a request, in the form
+// of the W3C Trace Context "traceparent" and "tracestate" headers.  See
+// https://www.w3.org/TR/trace-context/.
+//
+// When tracing is enabled, a trace context is encoded as a non-standard
+// "trace" field in the request object:
+//
+//	{"jsonrpc":"2.0", "id":1, "method":"M", "trace":{"traceparent":"00-..."}}
+type TraceContext struct {
+	Parent string `json:"traceparent"`
+	State  string `json:"tracestate,omitempty"`
+}
+
+// IsZero reports whether tc is empty.
+func (tc TraceContext) IsZero() bool { return tc.Parent == "" && tc.State == "" }
+
+// A Tracer propagates trace context between clients and servers, and reports
+// the start and end of request spans.  Set a Tracer in the [ServerOptions] and
+// [ClientOptions] to enable tracing.  A [Server] or [Client] without a Tracer
+// does not send trace context, and rejects requests that include it as
+// invalid.
+type Tracer interface {
+	// Inject returns the trace context to attach to an outbound request
+	// issued with ctx.  If Inject returns a zero TraceContext, no trace context
+	// is sent. A client calls Inject for each call and notification; a server
+	// calls it for each callback and notification pushed to the client.
+	Inject(ctx context.Context) TraceContext
+
+	// Start reports the start of the span for an inbound request, with the
+	// trace context sent by the caller (which may be zero).  It returns a
+	// context for the handler, and a function that the receiver calls with
+	// the error reported by the handler when the span ends.  A server calls
+	// Start for each request it executes, and a client for each callback it
+	// receives from the server.
+	Start(ctx context.Context, req *Request, tc TraceContext) (context.Context, func(error))
+}
+
+// injectTrace attaches the trace context for ctx reported by t (if any) to msg.
+func injectTrace(t Tracer, ctx context.Context, msg *jmessage) {
+	if t == nil {
+		return
+	}
+	tc := t.Inject(ctx)
+	if tc.IsZero() {
+		return
+	}
+	bits, err := json.Marshal(tc)
+	if err != nil {
+		return // should not be possible
+	}
+	if msg.X == nil {
+		msg.X = make(map[string]json.RawMessage)
+	}
+	msg.X[traceKey] = bits
+}
+
+// traceOf returns the trace context attached to req, or a zero value if req
+// does not have a valid trace context.
+func traceOf(req *Request) TraceContext {
+	var tc TraceContext
+	if raw, ok := req.ext[traceKey]; ok {
+		json.Unmarshal(raw, &tc) // N.B. ignore invalid values
+	}
+	return tc
+}
+
+// startTrace reports the start of a span for req to t, and returns the
+// context for the handler and a function to end the span. If t == nil, it
+// returns ctx unmodified and a no-op.
+func startTrace(t Tracer, ctx context.Context, req *Request) (context.Context, func(error)) {
+	if t == nil {
+		return ctx, func(error) {}
+	}
+	return t.Start(ctx, req, traceOf(req))
+}
+
+// traceKeys returns the extension keys to accept from the wire for t.
+func traceKeys(t Tracer) []string {
+	if t == nil {
+		return nil
+	}
+	return []string{traceKey}
+}
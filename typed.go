@@ -0,0 +1,151 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jrpc2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// CallAs invokes the specified method via cli, and decodes its result into a
+// value of type R.  Errors returned by the server have concrete type [*Error].
+//
+// For example:
+//
+//	sum, err := jrpc2.CallAs[int](ctx, cli, "Math.Add", []int{1, 3, 5, 7})
+func CallAs[R any](ctx context.Context, cli *Client, method string, params any) (R, error) {
+	var result R
+	if err := cli.CallResult(ctx, method, params, &result); err != nil {
+		var zero R
+		return zero, err
+	}
+	return result, nil
+}
+
+// A TypedCall is a call to be issued as part of a batch by [BatchAs], whose
+// result is decoded into a value of type R.
+type TypedCall[R any] struct {
+	Method string // the name of the method to call
+	Params any    // the parameters of the call (may be nil)
+
+	// When BatchAs succeeds, these fields are set to the result of the call,
+	// or the error reported for it by the server.  Errors reported by the
+	// server have concrete type [*Error].
+	Result R
+	Err    error
+}
+
+// spec returns the Spec for c, and reports false if c is nil.
+func (c *TypedCall[R]) spec() (Spec, bool) {
+	if c == nil {
+		return Spec{}, false
+	}
+	return Spec{Method: c.Method, Params: c.Params}, true
+}
+
+func (c *TypedCall[R]) setResponse(rsp *Response) {
+	if err := rsp.Error(); err != nil {
+		c.Err = filterError(err)
+	} else {
+		c.Err = rsp.UnmarshalResult(&c.Result)
+	}
+}
+
+// A BatchCall is a call whose result is decoded by [BatchAs].  The concrete
+// type of a BatchCall is [*TypedCall].
+type BatchCall interface {
+	spec() (Spec, bool)
+	setResponse(*Response)
+}
+
+// BatchAs issues the specified calls via cli as a single batch, and blocks
+// until all the responses return or ctx ends.  The result of each call is
+// decoded into its own result type.
+//
+// As with [Client.Batch], any error reported by BatchAs represents an error in
+// encoding or sending the batch.  Errors for individual calls are recorded in
+// the Err field of each call.  If any of the calls is nil, BatchAs reports an
+// error without sending the batch.  For example:
+//
+//	add := &jrpc2.TypedCall[int]{Method: "Math.Add", Params: []int{1, 2}}
+//	name := &jrpc2.TypedCall[string]{Method: "Status.Name"}
+//	if err := jrpc2.BatchAs(ctx, cli, add, name); err != nil {
+//	   log.Fatalf("Batch: %v", err)
+//	}
+//	// use add.Result, add.Err, name.Result, name.Err
+func BatchAs(ctx context.Context, cli *Client, calls ...BatchCall) error {
+	if len(calls) == 0 {
+		return errors.New("empty request batch")
+	}
+	specs := make([]Spec, len(calls))
+	for i, c := range calls {
+		var ok bool
+		if c != nil {
+			specs[i], ok = c.spec()
+		}
+		if !ok {
+			return fmt.Errorf("call %d of the batch is nil", i)
+		}
+	}
+	rsps, err := cli.Batch(ctx, specs)
+	if err != nil {
+		return err
+	}
+	for i, rsp := range rsps {
+		calls[i].setResponse(rsp)
+	}
+	return nil
+}
+
+// Method is a descriptor for a method with parameters of type P and a result
+// of type R.  A Method value can be shared between client and server code, so
+// that both agree on the name and types of the method, checked at compile
+// time.  On the server, use its Handler method to construct a handler for the
+// method; on the client, use its Call method to invoke it.
+//
+// For example:
+//
+//	var Add = jrpc2.Method[[]int, int]{Name: "Math.Add"}
+//
+//	// Server
+//	mux := handler.Map{
+//	   Add.Name: Add.Handler(func(ctx context.Context, vs []int) (int, error) { ... }),
+//	}
+//
+//	// Client
+//	sum, err := Add.Call(ctx, cli, []int{1, 3, 5, 7})
+type Method[P, R any] struct {
+	Name string // the name of the method
+}
+
+// Call invokes m via cli with the given parameters, and returns its result.
+// Errors returned by the server have concrete type [*Error].
+func (m Method[P, R]) Call(ctx context.Context, cli *Client, params P) (R, error) {
+	return CallAs[R](ctx, cli, m.Name, params)
+}
+
+// Notify sends a notification for m via cli with the given parameters.
+func (m Method[P, R]) Notify(ctx context.Context, cli *Client, params P) error {
+	return cli.Notify(ctx, m.Name, params)
+}
+
+// Batch returns a TypedCall for m with the given parameters, for use with
+// [BatchAs].
+func (m Method[P, R]) Batch(params P) *TypedCall[R] {
+	return &TypedCall[R]{Method: m.Name, Params: params}
+}
+
+// Handler returns a [Handler] for m that decodes the parameters of the request
+// into a value of type P, and calls fn.  If the parameters cannot be decoded,
+// the handler reports an InvalidParams error without calling fn.  If the
+// request has no parameters, fn receives the zero value of P.
+func (m Method[P, R]) Handler(fn func(context.Context, P) (R, error)) Handler {
+	return func(ctx context.Context, req *Request) (any, error) {
+		var params P
+		if err := req.UnmarshalParams(&params); err != nil {
+			return nil, err
+		}
+		return fn(ctx, params)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (C) 2017 Michael J. Fromberger. All Rights Reserved.
+
+package jrpc2
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"time"
+)
+
+// errRequestTimeout is the error reported for a request whose handler ran
+// longer than the SlowRequestLimit of the server.
+var errRequestTimeout = &Error{Code: DeadlineExceeded, Message: "request exceeded the server time limit"}
+
+// watch returns a context derived from ctx for the handler of req, which is
+// running on the calling goroutine, that is monitored according to the slow
+// request settings of the server.  The caller must call the returned function
+// when the handler is done; it reports whether the handler was cancelled for
+// exceeding the time limit.
+func (s *Server) watch(ctx context.Context, req *Request) (context.Context, func() bool) {
+	if s.slowWarn <= 0 && s.slowLimit <= 0 {
+		return ctx, func() bool { return false }
+	}
+	var timers []*time.Timer
+	if s.slowWarn > 0 {
+		gid := goroutineID()
+		timers = append(timers, time.AfterFunc(s.slowWarn, func() {
+			s.metrics.add(metricRequestsSlow, 1)
+			s.log("Slow request: method %q, ID %s, params %s, running for over %v\n%s",
+				req.method, req.ID(), req.params, s.slowWarn, goroutineStack(gid))
+		}))
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	if s.slowLimit > 0 {
+		timers = append(timers, time.AfterFunc(s.slowLimit, func() {
+			s.metrics.add(metricTimedOut, 1)
+			s.log("Cancelling request: method %q, ID %s, running for over %v", req.method, req.ID(), s.slowLimit)
+			cancel(errRequestTimeout)
+		}))
+	}
+	return ctx, func() bool {
+		for _, t := range timers {
+			t.Stop()
+		}
+		cancel(nil)
+		return context.Cause(ctx) == errRequestTimeout
+	}
+}
+
+// goroutineID returns the ID of the calling goroutine, as reported in the
+// header of its stack trace.
+func goroutineID() []byte {
+	var buf [64]byte
+	hdr := buf[:runtime.Stack(buf[:], false)] // "goroutine NNN [running]: ..."
+	fields := bytes.Fields(hdr)
+	if len(fields) < 2 {
+		return nil
+	}
+	return fields[1]
+}
+
+// goroutineStack returns the current stack trace of the goroutine with the
+// given ID, or a placeholder if it is not found.
+func goroutineStack(gid []byte) []byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	prefix := append(append([]byte("goroutine "), gid...), ' ')
+	for g := range bytes.SplitSeq(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(g, prefix) {
+			return g
+		}
+	}
+	return []byte("[handler stack not found]")
+}